@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+type BatchManager interface {
+	ListBatches(ctx context.Context) ([]types.BatchResponse, error)
+	GetBatch(ctx context.Context, batchID int) (types.BatchResponse, error)
+	DeleteBatch(ctx context.Context, batchID int) error
+}
+
+var _ BatchManager = (*fxmerkle.Client)(nil)
+
+func init() {
+	batchesCmd.AddCommand(batchesListCmd)
+	batchesCmd.AddCommand(batchesGetCmd)
+	batchesCmd.AddCommand(batchesDeleteCmd)
+}
+
+var batchesCmd = &cobra.Command{
+	Use:   "batches",
+	Short: "List, inspect and delete the batches stored by the server",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cmd.Help(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var batchesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the stored batches, oldest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		batches, err := client.ListBatches(cmd.Context())
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		for _, batch := range batches {
+			printBatch(batch)
+		}
+	},
+}
+
+var batchesGetCmd = &cobra.Command{
+	Use:   "get [batch]",
+	Short: "Describe a stored batch along with its files",
+	Run: func(cmd *cobra.Command, args []string) {
+		batchID, err := batchIDFromArgs(args)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		batch, err := client.GetBatch(cmd.Context(), batchID)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		printBatch(batch)
+		for _, file := range batch.Files {
+			fmt.Printf("  #%-6d %12d %s\n", file.Index, file.Size, file.Name)
+		}
+	},
+}
+
+var batchesDeleteCmd = &cobra.Command{
+	Use:   "delete [batch]",
+	Short: "Delete a stored batch along with its files",
+	Run: func(cmd *cobra.Command, args []string) {
+		batchID, err := batchIDFromArgs(args)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		if err = client.DeleteBatch(cmd.Context(), batchID); err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		fmt.Printf("Deleted batch %d\n", batchID)
+	},
+}
+
+func batchIDFromArgs(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("Please enter one batch")
+	}
+
+	batchID, err := strconv.Atoi(args[0])
+	if err != nil || batchID < 1 {
+		return 0, errors.New("The batch must be a number starting from 1")
+	}
+
+	return batchID, nil
+}
+
+func printBatch(batch types.BatchResponse) {
+	fmt.Printf(
+		"Batch %d: %d files, %d bytes, created %s, root %s\n",
+		batch.ID, batch.FileCount, batch.Size, batch.CreatedAt.Format(time.RFC3339), batch.Root,
+	)
+}
@@ -1,19 +1,37 @@
 package cli
 
 import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/conf"
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
 )
 
 func init() {
 	Cmd.AddCommand(uploadCmd)
 	Cmd.AddCommand(downloadCmd)
+	Cmd.AddCommand(downloadAllCmd)
+	Cmd.AddCommand(dagCmd)
+	Cmd.AddCommand(batchesCmd)
+	Cmd.AddCommand(lsCmd)
+
+	Cmd.PersistentFlags().Duration("timeout", 0, "time limit of the whole command, such as 10m, none by default")
 }
 
 const (
 	defaultServerURL          = "http://localhost:8080"
 	defaultMerkleRootFilename = ".runtime/merkleroot"
+	defaultTreeHeadFilename   = ".runtime/treehead.json"
 )
 
 var Cmd = &cobra.Command{
@@ -24,4 +42,95 @@ var Cmd = &cobra.Command{
 			log.Fatal(err)
 		}
 	},
+	// the requests of a command carry its context, bounded by the timeout if any, a large download
+	// is only limited by it rather than by a timeout per request.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if timeout, _ := cmd.Flags().GetDuration("timeout"); timeout > 0 {
+			var ctx context.Context
+			ctx, cancelCommand = context.WithTimeout(cmd.Context(), timeout)
+			cmd.SetContext(ctx)
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelCommand()
+	},
+}
+
+// cancelCommand releases the context of the running command.
+var cancelCommand context.CancelFunc = func() {}
+
+// pinnedPublicKey returns the server public key pinned in the environment, either directly as a
+// hexadecimal key or through a file containing it, nil if no key is pinned.
+func pinnedPublicKey() (ed25519.PublicKey, error) {
+	hexKey := conf.EnvStr("SERVER_PUBLIC_KEY", "")
+	if filename := conf.EnvStr("SERVER_PUBLIC_KEY_FILE", ""); filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		hexKey = string(content)
+	}
+
+	if hexKey == "" {
+		return nil, nil
+	}
+
+	return sth.ParsePublicKey(hexKey)
+}
+
+// newHttpClient returns the http client of the commands, verifying the server certificate against
+// the CA bundle of SERVER_CA_FILE if set and presenting the CLIENT_CERT_FILE certificate if set.
+// Connecting, the TLS handshake and waiting for the response headers are bounded, reading the
+// response body is only bounded by the context of the command.
+func newHttpClient() (*http.Client, error) {
+	tlsConfig, err := fxmerkle.NewTLSConfig(
+		conf.EnvStr("SERVER_CA_FILE", ""),
+		conf.EnvStr("CLIENT_CERT_FILE", ""),
+		conf.EnvStr("CLIENT_KEY_FILE", ""),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   conf.EnvDuration("CONNECT_TIMEOUT", 10*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = conf.EnvDuration("TLS_HANDSHAKE_TIMEOUT", 10*time.Second)
+	transport.ResponseHeaderTimeout = conf.EnvDuration("RESPONSE_HEADER_TIMEOUT", time.Minute)
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// newClient returns the client of the server at SERVER_URL, sending the API_KEY along and pinned to
+// the server public key if there is one.
+func newClient(opts ...fxmerkle.Option) (*fxmerkle.Client, error) {
+	publicKey, err := pinnedPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("pinned server public key is unreadable: %s", err)
+	}
+
+	httpClient, err := newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return fxmerkle.New(
+		conf.EnvStr("SERVER_URL", defaultServerURL),
+		append([]fxmerkle.Option{
+			fxmerkle.WithHTTPClient(httpClient),
+			fxmerkle.WithAPIKey(conf.EnvStr("API_KEY", "")),
+			fxmerkle.WithPublicKey(publicKey),
+			fxmerkle.WithRetryPolicy(fxmerkle.RetryPolicy{
+				MaxAttempts:    conf.EnvInt("RETRY_MAX_ATTEMPTS", fxmerkle.DefaultRetryPolicy.MaxAttempts),
+				InitialBackoff: conf.EnvDuration("RETRY_INITIAL_BACKOFF", fxmerkle.DefaultRetryPolicy.InitialBackoff),
+				MaxBackoff:     conf.EnvDuration("RETRY_MAX_BACKOFF", fxmerkle.DefaultRetryPolicy.MaxBackoff),
+			}),
+		}, opts...)...,
+	), nil
 }
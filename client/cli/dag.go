@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+)
+
+type DagDownloader interface {
+	DownloadDagPath(
+		ctx context.Context,
+		root fxmerkle.Root,
+		nodePath string,
+		destination io.Writer,
+	) (dag.Entry, []dag.Entry, error)
+}
+
+var _ DagDownloader = (*fxmerkle.Client)(nil)
+
+func init() {
+	dagCmd.Flags().String("out", "", "file to write a verified file to, defaults to stdout")
+}
+
+var dagCmd = &cobra.Command{
+	Use:   "dag",
+	Short: "Verify a path of the last batch uploaded with --dag, print a directory listing or download a file",
+	Long:  "E.g. args: <dir/file> | args: <dir> | no args for the root directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			fmt.Println("Please enter at most one path of the uploaded directory")
+
+			return
+		}
+
+		var nodePath string
+		if len(args) == 1 {
+			nodePath = args[0]
+		}
+
+		outFilename, _ := cmd.Flags().GetString("out")
+
+		client, root, err := newDownloader()
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		if root.TreeHead == nil {
+			fmt.Println("No tree head is stored from a previous upload")
+
+			return
+		}
+
+		// the file is only written once verified, so it is buffered until then.
+		var content bytes.Buffer
+		entry, listing, err := client.DownloadDagPath(cmd.Context(), root, nodePath, &content)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		if entry.Kind == dag.KindDir {
+			for _, e := range listing {
+				fmt.Printf("%-4s %10d %s %s\n", e.Kind, e.Size, dag.CID(e.Hash), e.Name)
+			}
+
+			return
+		}
+
+		if outFilename == "" {
+			_, _ = os.Stdout.Write(content.Bytes())
+
+			return
+		}
+
+		if err = os.WriteFile(outFilename, content.Bytes(), 0644); err != nil {
+			fmt.Printf("Failed to write %s: %s\n", outFilename, err)
+		}
+	},
+}
@@ -1,64 +1,268 @@
 package cli
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/spf13/cobra"
 
-	httpclient "github.com/TxCorpi0x/file-upload-merkle/client/http"
 	"github.com/TxCorpi0x/file-upload-merkle/conf"
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
 )
 
 type Downloader interface {
-	DownloadFileAt(index int, destination *os.File) error
+	Download(ctx context.Context, root fxmerkle.Root, index int, destination io.Writer) (fxmerkle.FileInfo, error)
 }
 
-var _ Downloader = (*httpclient.HttpDownloader)(nil)
+var _ Downloader = (*fxmerkle.Client)(nil)
+
+type FilesDownloader interface {
+	DownloadFilesTo(ctx context.Context, root fxmerkle.Root, indexes []int, outDir string, jobs int) ([]fxmerkle.DownloadedFile, error)
+}
+
+var _ FilesDownloader = (*fxmerkle.Client)(nil)
+
+func init() {
+	downloadCmd.Flags().String(
+		"out",
+		"",
+		"file or directory to write the files to, - for stdout, defaults to their names in the current directory",
+	)
+	downloadCmd.Flags().Int("jobs", 4, "number of files downloaded at once")
+	downloadCmd.Flags().Bool("force", false, "overwrite the existing output files")
+	addProgressFlag(downloadCmd)
+}
+
+// The exit codes of the download command.
+const (
+	// exitFailure is the exit code when a file fails to download or to be verified.
+	exitFailure = 1
+	// exitUsage is the exit code when the arguments or flags are invalid.
+	exitUsage = 2
+)
 
 var downloadCmd = &cobra.Command{
-	Use:   "download",
-	Short: "Download a file by index, from the server, and verify its integrity",
+	Use:   "download <index|from-to>...",
+	Short: "Download files by index, from the server, and verify their integrity",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			fmt.Println("Please enter one index of an uploaded file to download")
+		if len(args) == 0 {
+			exitWithError(exitUsage, errors.New("Please enter the indexes of the uploaded files to download, such as 1 2 5-20"))
+		}
 
-			return
+		indexes, err := parseIndexes(args)
+		if err != nil {
+			exitWithError(exitUsage, err)
 		}
 
-		index, err := strconv.Atoi(args[0])
-		if err != nil || index < 1 {
-			fmt.Println("The index must be a number starting from 1")
+		out, _ := cmd.Flags().GetString("out")
+		if out == "-" && (len(args) > 1 || len(indexes) > 1) {
+			exitWithError(exitUsage, errors.New("a single file can be written to stdout, give a directory in --out for several files"))
+		}
 
-			return
+		progress, err := progressOption(cmd)
+		if err != nil {
+			exitWithError(exitUsage, err)
 		}
 
-		rootHash, err := os.ReadFile(conf.EnvStr("MERKLE_ROOT_FILENAME", defaultMerkleRootFilename))
+		force, _ := cmd.Flags().GetBool("force")
+		client, root, err := newDownloader(progress, fxmerkle.WithOverwrite(force))
 		if err != nil {
-			fmt.Println("Merkle Root hash is missing or unreadable:", err)
+			exitWithError(exitFailure, err)
+		}
+
+		// a single file is written to the file given, or to stdout, nothing is written unless it
+		// is verified.
+		if len(args) == 1 && len(indexes) == 1 && out != "" && !isDirectoryPath(out) {
+			if out == "-" {
+				if _, err = client.Download(cmd.Context(), root, indexes[0], os.Stdout); err != nil {
+					exitWithError(exitFailure, err)
+				}
+
+				return
+			}
+
+			if err = downloadToFile(cmd.Context(), client, root, indexes[0], out, force); err != nil {
+				exitWithError(exitFailure, withForceHint(err))
+			}
+
+			fmt.Printf("Verified and downloaded file at index #%d: %s\n", indexes[0], out)
 
 			return
 		}
 
-		rootHash, err = hex.DecodeString(string(rootHash))
-		if err != nil {
-			fmt.Println("Error parsing root hash from file:", err)
+		if out == "" {
+			out = "."
 		}
 
-		downloader := httpclient.NewHttpDownloader(
-			&http.Client{Timeout: time.Second * 30},
-			conf.EnvStr("SERVER_URL", defaultServerURL),
-			rootHash,
-		)
+		jobs, _ := cmd.Flags().GetInt("jobs")
+		downloaded, err := client.DownloadFilesTo(cmd.Context(), root, indexes, out, jobs)
 
-		if err := downloader.DownloadFileAt(index, os.Stdout); err != nil {
-			fmt.Println(err)
+		failed, unverifiedNames := 0, 0
+		for _, file := range downloaded {
+			if file.Err != nil {
+				failed++
+				fmt.Fprintln(os.Stderr, file.Err)
 
-			return
+				continue
+			}
+
+			if !file.NameVerified {
+				unverifiedNames++
+			}
+
+			fmt.Printf("Verified and downloaded file at index #%d: %s\n", file.Index, file.Path)
+		}
+
+		// in the content leaf format, the contents are verified but not the names they are written under.
+		if unverifiedNames > 0 {
+			fmt.Fprintf(
+				os.Stderr,
+				"Warning: %d of the files are written under the names sent by the server, which the merkle root doesn't commit to in the content leaf format; give a file path in --out or upload with --leaf-format metadata\n",
+				unverifiedNames,
+			)
+		}
+
+		if len(downloaded) > 1 {
+			fmt.Printf("%d files verified, %d failed\n", len(downloaded)-failed, failed)
+		}
+		if errors.Is(err, os.ErrExist) {
+			fmt.Fprintln(os.Stderr, forceHint)
+		}
+		if err != nil {
+			os.Exit(exitFailure)
 		}
 	},
 }
+
+// forceHint is printed along with the errors of the files refusing to replace an existing file.
+const forceHint = "Pass --force to overwrite the existing files"
+
+// withForceHint adds the hint to pass --force to the error of a file refusing to replace an
+// existing one.
+func withForceHint(err error) error {
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w\n%s", err, forceHint)
+	}
+
+	return err
+}
+
+// exitWithError prints the error to stderr and exits with the code.
+func exitWithError(code int, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(code)
+}
+
+// isDirectoryPath tells whether the output path is a directory, an existing one or one ending with
+// a path separator.
+func isDirectoryPath(path string) bool {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return true
+	}
+
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
+
+// downloadToFile downloads the file at the index and writes it to the output path once verified,
+// replacing an existing file only if overwrite is set.
+func downloadToFile(ctx context.Context, client Downloader, root fxmerkle.Root, index int, outPath string, overwrite bool) error {
+	var content bytes.Buffer
+	info, err := client.Download(ctx, root, index, &content)
+	if err != nil {
+		return err
+	}
+
+	// attributes are only sent along when the leaf commits to them.
+	if err = fxmerkle.WriteFile(outPath, content.Bytes(), info.Mode, info.ModTime, overwrite); err != nil {
+		return fmt.Errorf("error writing %s: %w", outPath, err)
+	}
+
+	return nil
+}
+
+// maxIndexRange bounds the number of files of an index range.
+const maxIndexRange = 10000
+
+// parseIndexes parses the file indexes given as numbers or ranges of numbers, such as 5-20.
+func parseIndexes(args []string) ([]int, error) {
+	var indexes []int
+	for _, arg := range args {
+		from, to, isRange := strings.Cut(arg, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil || first < 1 {
+			return nil, fmt.Errorf("invalid index %q, indexes are numbers starting from 1", arg)
+		}
+
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil || last < first || last-first >= maxIndexRange {
+				return nil, fmt.Errorf("invalid index range %q, expected from-to with from <= to, of at most %d files", arg, maxIndexRange)
+			}
+		}
+
+		for index := first; index <= last; index++ {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes, nil
+}
+
+// newDownloader creates a client along with the root stored at upload time, which downloads are
+// verified against, and the tree head stored along with it, if any.
+func newDownloader(opts ...fxmerkle.Option) (client *fxmerkle.Client, root fxmerkle.Root, err error) {
+	rootHash, err := os.ReadFile(conf.EnvStr("MERKLE_ROOT_FILENAME", defaultMerkleRootFilename))
+	if err != nil {
+		err = fmt.Errorf("merkle root hash is missing or unreadable: %s", err)
+
+		return
+	}
+
+	root.Hash, err = hex.DecodeString(string(rootHash))
+	if err != nil {
+		err = fmt.Errorf("error parsing root hash from file: %s", err)
+
+		return
+	}
+
+	root.TreeHead, err = storedTreeHead()
+	if err != nil {
+		err = fmt.Errorf("error parsing tree head from file: %s", err)
+
+		return
+	}
+
+	client, err = newClient(opts...)
+
+	return
+}
+
+// storedTreeHead reads the tree head stored at upload time, nil if there is none.
+func storedTreeHead() (*sth.SignedTreeHead, error) {
+	treeHeadJson, err := os.ReadFile(conf.EnvStr("TREE_HEAD_FILENAME", defaultTreeHeadFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var treeHead sth.SignedTreeHead
+	if err = json.Unmarshal(treeHeadJson, &treeHead); err != nil {
+		return nil, err
+	}
+
+	return &treeHead, nil
+}
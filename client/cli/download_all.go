@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+type BatchDownloader interface {
+	DownloadBatchTo(ctx context.Context, root fxmerkle.Root, batchID int, outDir string) ([]types.ManifestFile, error)
+}
+
+var _ BatchDownloader = (*fxmerkle.Client)(nil)
+
+func init() {
+	downloadAllCmd.Flags().String("out", ".", "directory to restore the batch files to")
+	downloadAllCmd.Flags().Int("batch", 0, "batch to download, defaults to the last uploaded batch")
+	downloadAllCmd.Flags().Bool("force", false, "overwrite the existing files of the output directory")
+}
+
+var downloadAllCmd = &cobra.Command{
+	Use:   "download-all",
+	Short: "Download every file of a batch as an archive, verify and restore them in a directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		outDir, _ := cmd.Flags().GetString("out")
+		batchID, _ := cmd.Flags().GetInt("batch")
+		force, _ := cmd.Flags().GetBool("force")
+
+		client, root, err := newDownloader(fxmerkle.WithOverwrite(force))
+		if err != nil {
+			exitWithError(exitFailure, err)
+		}
+
+		if batchID == 0 {
+			if root.TreeHead == nil {
+				exitWithError(exitUsage, errors.New("Please enter the batch to download, no tree head is stored from a previous upload"))
+			}
+
+			batchID = root.TreeHead.BatchID
+		}
+
+		restored, err := client.DownloadBatchTo(cmd.Context(), root, batchID, outDir)
+		if err != nil {
+			exitWithError(exitFailure, withForceHint(err))
+		}
+
+		for _, f := range restored {
+			fmt.Printf("Verified and restored file at index #%d: %s\n", f.Index, f.Name)
+		}
+	},
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+type FileLister interface {
+	ListFiles(ctx context.Context, batchID int, cursor string, limit int) (types.FilesResponse, error)
+}
+
+var _ FileLister = (*fxmerkle.Client)(nil)
+
+func init() {
+	lsCmd.Flags().Int("batch", 0, "batch to list, defaults to the last batch")
+	lsCmd.Flags().Int("limit", 0, "number of files fetched per request, the server default if zero")
+	lsCmd.Flags().Bool("json", false, "print the files as json instead of a table")
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the files of a batch stored by the server",
+	Run: func(cmd *cobra.Command, args []string) {
+		batchID, _ := cmd.Flags().GetInt("batch")
+		limit, _ := cmd.Flags().GetInt("limit")
+		asJson, _ := cmd.Flags().GetBool("json")
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		listing, err := listFiles(cmd.Context(), client, batchID, limit)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		if asJson {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err = encoder.Encode(listing); err != nil {
+				fmt.Println(err)
+			}
+
+			return
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(table, "INDEX\tSIZE\tCONTENT TYPE\tHASH\tNAME")
+		for _, file := range listing.Files {
+			_, _ = fmt.Fprintf(table, "%d\t%d\t%s\t%s\t%s\n", file.Index, file.Size, file.ContentType, file.Hash, file.Name)
+		}
+		if err = table.Flush(); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+// listFiles fetches every page of the batch files, the pages all list the batch of the first one.
+func listFiles(ctx context.Context, lister FileLister, batchID, limit int) (listing types.FilesResponse, err error) {
+	cursor := ""
+	for {
+		var page types.FilesResponse
+		if page, err = lister.ListFiles(ctx, batchID, cursor, limit); err != nil {
+			return
+		}
+
+		listing.BatchID = page.BatchID
+		listing.Files = append(listing.Files, page.Files...)
+		if page.NextCursor == "" {
+			return
+		}
+
+		cursor = page.NextCursor
+	}
+}
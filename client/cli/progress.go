@@ -0,0 +1,374 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+)
+
+// The formats of the progress reported on stderr by the upload and download commands.
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressJson = "json"
+	progressNone = "none"
+)
+
+const (
+	progressBarWidth    = 30
+	progressBarInterval = 100 * time.Millisecond
+	progressJsonPeriod  = time.Second
+)
+
+func addProgressFlag(cmd *cobra.Command) {
+	cmd.Flags().String(
+		"progress",
+		progressAuto,
+		"progress reported on stderr: bar, json (one line per second), none, or auto (bar on a terminal, json otherwise)",
+	)
+}
+
+// progressOption returns the client option reporting the progress on stderr in the format of the
+// command progress flag.
+func progressOption(cmd *cobra.Command) (fxmerkle.Option, error) {
+	format, _ := cmd.Flags().GetString("progress")
+	if format == progressAuto {
+		format = progressJson
+		if isTerminal(os.Stderr) {
+			format = progressBar
+		}
+	}
+
+	switch format {
+	case progressBar:
+		return fxmerkle.WithProgress(&barProgress{transferProgress: newTransferProgress(), w: os.Stderr}), nil
+	case progressJson:
+		return fxmerkle.WithProgress(&jsonProgress{transferProgress: newTransferProgress(), encoder: json.NewEncoder(os.Stderr)}), nil
+	case progressNone:
+		return fxmerkle.WithProgress(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown progress format %q, expected auto, bar, json or none", format)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// transferProgress keeps track of the progress of a transfer for the reporters to render it,
+// periodically while the transfer goes on, even when no byte moves.
+type transferProgress struct {
+	mu       sync.Mutex
+	started  time.Time
+	files    int
+	size     int64
+	bytes    map[int]int64
+	sizes    map[int]int64
+	finished map[int]error
+	stop     chan struct{}
+}
+
+func newTransferProgress() transferProgress {
+	return transferProgress{size: -1}
+}
+
+// progressStats are the overall figures of a transfer.
+type progressStats struct {
+	Bytes          int64    `json:"bytes"`
+	TotalBytes     int64    `json:"totalBytes"`
+	BytesPerSecond float64  `json:"bytesPerSecond"`
+	EtaSeconds     *float64 `json:"etaSeconds,omitempty"`
+	Files          int      `json:"files"`
+	FilesDone      int      `json:"filesDone"`
+	FilesFailed    int      `json:"filesFailed"`
+	ElapsedSeconds float64  `json:"elapsedSeconds"`
+}
+
+// start resets the progress for a transfer and renders it every period until it is stopped.
+func (p *transferProgress) start(now time.Time, files int, size int64, period time.Duration, render func(time.Time)) {
+	p.started = now
+	p.files, p.size = files, size
+	p.bytes = make(map[int]int64, files)
+	p.sizes = make(map[int]int64, files)
+	p.finished = make(map[int]error, files)
+
+	p.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				p.mu.Lock()
+				select {
+				case <-stop:
+				default:
+					render(now)
+				}
+				p.mu.Unlock()
+			}
+		}
+	}(p.stop)
+}
+
+// end stops rendering the progress periodically.
+func (p *transferProgress) end() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *transferProgress) update(file fxmerkle.FileTransfer) {
+	p.bytes[file.Index] = file.Bytes
+	p.sizes[file.Index] = file.Size
+}
+
+func (p *transferProgress) finish(file fxmerkle.FileTransfer, err error) {
+	if err == nil {
+		p.update(file)
+	}
+	p.finished[file.Index] = err
+}
+
+func (p *transferProgress) stats(now time.Time) (stats progressStats) {
+	stats.Files = p.files
+	for _, n := range p.bytes {
+		stats.Bytes += n
+	}
+
+	for _, err := range p.finished {
+		if err != nil {
+			stats.FilesFailed++
+		} else {
+			stats.FilesDone++
+		}
+	}
+
+	// downloads only know the total size once every file is started.
+	stats.TotalBytes = p.size
+	if stats.TotalBytes < 0 && len(p.sizes) == p.files {
+		stats.TotalBytes = 0
+		for _, size := range p.sizes {
+			if size < 0 {
+				stats.TotalBytes = -1
+
+				break
+			}
+
+			stats.TotalBytes += size
+		}
+	}
+
+	elapsed := now.Sub(p.started).Seconds()
+	stats.ElapsedSeconds = elapsed
+	if elapsed > 0 {
+		stats.BytesPerSecond = float64(stats.Bytes) / elapsed
+	}
+
+	filesLeft := p.files - stats.FilesDone - stats.FilesFailed
+	switch {
+	case stats.TotalBytes >= stats.Bytes && stats.BytesPerSecond > 0:
+		eta := float64(stats.TotalBytes-stats.Bytes) / stats.BytesPerSecond
+		stats.EtaSeconds = &eta
+	case stats.TotalBytes < 0 && stats.FilesDone > 0:
+		eta := elapsed * float64(filesLeft) / float64(stats.FilesDone+stats.FilesFailed)
+		stats.EtaSeconds = &eta
+	}
+
+	return
+}
+
+// barProgress renders the progress as a bar on the last line of a terminal, with a line per
+// finished file above it.
+type barProgress struct {
+	transferProgress
+	w io.Writer
+}
+
+func (p *barProgress) TransferStarted(files int, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.start(now, files, size, progressBarInterval, p.render)
+	p.render(now)
+}
+
+func (p *barProgress) FileProgress(file fxmerkle.FileTransfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.update(file)
+}
+
+func (p *barProgress) FileFinished(file fxmerkle.FileTransfer, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finish(file, err)
+
+	status := fmt.Sprintf("done   %s (%s)", fileLabel(file), formatBytes(file.Size))
+	if err != nil {
+		status = fmt.Sprintf("failed %s: %s", fileLabel(file), err)
+	}
+	_, _ = fmt.Fprintf(p.w, "\r\033[K%s\n", status)
+	p.render(time.Now())
+}
+
+func (p *barProgress) TransferFinished(error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.end()
+	p.render(time.Now())
+	_, _ = fmt.Fprintln(p.w)
+}
+
+func (p *barProgress) render(now time.Time) {
+	stats := p.stats(now)
+
+	// the bar fills with the bytes if the total size is known, with the files otherwise.
+	done := 0.0
+	switch {
+	case stats.TotalBytes > 0:
+		done = float64(stats.Bytes) / float64(stats.TotalBytes)
+	case stats.Files > 0:
+		done = float64(stats.FilesDone+stats.FilesFailed) / float64(stats.Files)
+	}
+	done = min(max(done, 0), 1)
+
+	filled := int(done * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	if filled > 0 && filled < progressBarWidth {
+		bar = strings.Repeat("=", filled-1) + ">" + strings.Repeat(" ", progressBarWidth-filled)
+	}
+
+	size := formatBytes(stats.Bytes)
+	if stats.TotalBytes >= 0 {
+		size += " / " + formatBytes(stats.TotalBytes)
+	}
+
+	eta := "--"
+	if stats.EtaSeconds != nil {
+		eta = (time.Duration(*stats.EtaSeconds) * time.Second).String()
+	}
+
+	line := fmt.Sprintf(
+		"[%s] %3.0f%%  %s  %s/s  ETA %s  %d/%d files",
+		bar, done*100, size, formatBytes(int64(stats.BytesPerSecond)), eta, stats.FilesDone, stats.Files,
+	)
+	if stats.FilesFailed > 0 {
+		line += fmt.Sprintf(", %d failed", stats.FilesFailed)
+	}
+
+	_, _ = fmt.Fprintf(p.w, "\r\033[K%s", line)
+}
+
+// jsonProgress renders the progress as json lines: one when the transfer starts, one per second
+// while it goes on, one per finished file and one when it is finished.
+type jsonProgress struct {
+	transferProgress
+	encoder *json.Encoder
+}
+
+type progressEvent struct {
+	Event string `json:"event"`
+	*progressStats
+	*fileEvent
+	Error string `json:"error,omitempty"`
+}
+
+type fileEvent struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+}
+
+func (p *jsonProgress) TransferStarted(files int, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.start(now, files, size, progressJsonPeriod, func(now time.Time) { p.emit("progress", now, nil, nil) })
+	p.emit("start", now, nil, nil)
+}
+
+func (p *jsonProgress) FileProgress(file fxmerkle.FileTransfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.update(file)
+}
+
+func (p *jsonProgress) FileFinished(file fxmerkle.FileTransfer, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finish(file, err)
+
+	event := &fileEvent{Index: file.Index, Name: file.Name, Size: file.Size, Status: "done"}
+	if err != nil {
+		event.Status = "failed"
+	}
+	p.emit("file", time.Now(), event, err)
+}
+
+func (p *jsonProgress) TransferFinished(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.end()
+	p.emit("finish", time.Now(), nil, err)
+}
+
+func (p *jsonProgress) emit(event string, now time.Time, file *fileEvent, err error) {
+	line := progressEvent{Event: event, fileEvent: file}
+	if file == nil {
+		stats := p.stats(now)
+		line.progressStats = &stats
+	}
+	if err != nil {
+		line.Error = err.Error()
+	}
+
+	_ = p.encoder.Encode(line)
+}
+
+// fileLabel names the file, by its index until its name is known.
+func fileLabel(file fxmerkle.FileTransfer) string {
+	if file.Name == "" {
+		return fmt.Sprintf("#%d", file.Index)
+	}
+
+	return file.Name
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", max(n, 0))
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,25 +1,51 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/spf13/cobra"
 
-	httpclient "github.com/TxCorpi0x/file-upload-merkle/client/http"
 	"github.com/TxCorpi0x/file-upload-merkle/conf"
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
 	"github.com/TxCorpi0x/file-upload-merkle/types"
 )
 
 type Uploader interface {
-	UploadFilesFrom(filePaths []string) ([]types.UploadedFile, string, error)
+	Upload(ctx context.Context, files []fxmerkle.File, opts ...fxmerkle.UploadOption) (
+		[]types.UploadedFile,
+		sth.SignedTreeHead,
+		error,
+	)
 }
 
-var _ Uploader = (*httpclient.HttpUploader)(nil)
+var _ Uploader = (*fxmerkle.Client)(nil)
+
+func init() {
+	uploadCmd.Flags().String(
+		"ordering",
+		string(types.OrderingPath),
+		"order of the files as tree leaves: path (canonical, sorted by relative path) or upload (as listed)",
+	)
+	uploadCmd.Flags().String(
+		"leaf-format",
+		string(merkle.LeafFormatContent),
+		"data committed to by the tree leaves: content, metadata (path, size and content hash) or metadata+attrs (mode and mtime too)",
+	)
+	uploadCmd.Flags().Bool("dag", false, "also commit to the directory structure as a merkle dag, see the dag command")
+	uploadCmd.Flags().Int(
+		"jobs",
+		1,
+		"number of files sent at a time through an upload session, a single streamed request when 1",
+	)
+	addProgressFlag(uploadCmd)
+}
 
 var uploadCmd = &cobra.Command{
 	Use:   "upload",
@@ -32,16 +58,54 @@ var uploadCmd = &cobra.Command{
 			return
 		}
 
-		filePaths, err := argsToFilesToUpload(args)
+		orderingName, _ := cmd.Flags().GetString("ordering")
+		ordering, err := types.ParseOrdering(orderingName)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		leafFormatName, _ := cmd.Flags().GetString("leaf-format")
+		leafFormat, err := merkle.ParseLeafFormat(leafFormatName)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		withDag, _ := cmd.Flags().GetBool("dag")
+		jobs, _ := cmd.Flags().GetInt("jobs")
+
+		files, err := argsToFilesToUpload(args)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		progress, err := progressOption(cmd)
+		if err != nil {
+			fmt.Println(err)
+
+			return
+		}
+
+		client, err := newClient(progress)
 		if err != nil {
 			fmt.Println(err)
 
 			return
 		}
 
-		serverURL := conf.EnvStr("SERVER_URL", defaultServerURL)
-		uploader := httpclient.NewHttpUploader(&http.Client{Timeout: time.Second * 30}, serverURL)
-		uploadedFiles, merkleRoot, err := uploader.UploadFilesFrom(filePaths)
+		uploadedFiles, treeHead, err := client.Upload(
+			cmd.Context(),
+			files,
+			fxmerkle.WithOrdering(ordering),
+			fxmerkle.WithLeafFormat(leafFormat),
+			fxmerkle.WithDag(withDag),
+			fxmerkle.WithConcurrency(jobs),
+		)
 		if err != nil {
 			fmt.Println(err)
 
@@ -53,17 +117,39 @@ var uploadCmd = &cobra.Command{
 		}
 
 		merkleRootFilename := conf.EnvStr("MERKLE_ROOT_FILENAME", defaultMerkleRootFilename)
-		if err = os.WriteFile(merkleRootFilename, []byte(merkleRoot), 0644); err != nil {
+		if err = os.WriteFile(merkleRootFilename, []byte(treeHead.Root), 0644); err != nil {
 			fmt.Printf("Failed to store merkle root: %s\n", err)
 
 			return
 		}
 
-		fmt.Println("Merkle Root hash:", merkleRoot)
+		// keep the tree head the server committed to, signed or not, as evidence.
+		treeHeadJson, err := json.Marshal(treeHead)
+		if err != nil {
+			fmt.Printf("Failed to encode tree head: %s\n", err)
+
+			return
+		}
+
+		treeHeadFilename := conf.EnvStr("TREE_HEAD_FILENAME", defaultTreeHeadFilename)
+		if err = os.WriteFile(treeHeadFilename, treeHeadJson, 0644); err != nil {
+			fmt.Printf("Failed to store tree head: %s\n", err)
+
+			return
+		}
+
+		fmt.Println("Batch:", treeHead.BatchID)
+		fmt.Println("Merkle Root hash:", treeHead.Root)
+		if treeHead.DagRoot != "" {
+			fmt.Println("Dag root:", treeHead.DagRoot)
+		}
+		if treeHead.IsSigned() {
+			fmt.Println("Tree head signed by:", treeHead.PublicKey)
+		}
 	},
 }
 
-func argsToFilesToUpload(args []string) (filePaths []string, err error) {
+func argsToFilesToUpload(args []string) (files []fxmerkle.File, err error) {
 	// Check whether the 1st arg is a directory path
 	isDirectory, err := isDirectory(args[0])
 	if err != nil {
@@ -73,21 +159,34 @@ func argsToFilesToUpload(args []string) (filePaths []string, err error) {
 	}
 
 	if isDirectory {
-		filePaths, err = listFilesInDirectory(args[0])
+		files, err = listFilesInDirectory(args[0])
 		if err != nil {
 			err = fmt.Errorf("error listing files inside of %s: %v", args[0], err)
 
 			return
 		}
 	} else {
+		// files are uploaded under their base name, two files can't share one.
+		argsByName := make(map[string]string, len(args))
 		for _, arg := range args {
-			if _, err := os.Stat(arg); err == nil {
-				filePaths = append(filePaths, arg)
+			file, err := fxmerkle.LocalFile(arg, filepath.Base(arg))
+			if err != nil {
+				continue
+			}
+
+			if previousArg, found := argsByName[file.Name]; found {
+				return nil, fmt.Errorf(
+					"%s and %s would both be uploaded as %s, upload their common directory instead",
+					previousArg, arg, file.Name,
+				)
 			}
+			argsByName[file.Name] = arg
+
+			files = append(files, file)
 		}
 	}
 
-	if len(filePaths) == 0 {
+	if len(files) == 0 {
 		err = errors.New("none of the files/dir specified can be found")
 	}
 
@@ -103,8 +202,10 @@ func isDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), nil
 }
 
-func listFilesInDirectory(directoryPath string) ([]string, error) {
-	var files []string
+// listFilesInDirectory lists the files under the directory, named after their slash separated path
+// relative to the directory so that the directory structure is kept on the server.
+func listFilesInDirectory(directoryPath string) ([]fxmerkle.File, error) {
+	var files []fxmerkle.File
 
 	err := filepath.Walk(directoryPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -113,7 +214,17 @@ func listFilesInDirectory(directoryPath string) ([]string, error) {
 
 		// Exclude directories
 		if !info.IsDir() {
-			files = append(files, path)
+			relativePath, err := filepath.Rel(directoryPath, path)
+			if err != nil {
+				return err
+			}
+
+			file, err := fxmerkle.LocalFile(path, filepath.ToSlash(relativePath))
+			if err != nil {
+				return err
+			}
+
+			files = append(files, file)
 		}
 
 		return nil
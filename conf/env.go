@@ -3,6 +3,7 @@ package conf
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 // EnvInt returns the integer environment variable as config.
@@ -24,3 +25,23 @@ func EnvStr(name, defaultValue string) (value string) {
 
 	return
 }
+
+// EnvBool returns the boolean environment variable as config.
+func EnvBool(name string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// EnvDuration returns the duration environment variable as config, such as 24h or 90m.
+func EnvDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
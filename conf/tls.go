@@ -0,0 +1,22 @@
+package conf
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// CertPoolFromFile returns the pool of the PEM encoded certificates of the CA bundle file.
+func CertPoolFromFile(filename string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no PEM certificate found in CA bundle %s", filename)
+	}
+
+	return pool, nil
+}
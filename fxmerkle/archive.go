@@ -0,0 +1,236 @@
+package fxmerkle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// DownloadBatchTo downloads the archive of a batch, verifies every file against the root and
+// restores the files under the output directory. Files are only written once they are verified.
+func (c *Client) DownloadBatchTo(
+	ctx context.Context,
+	root Root,
+	batchID int,
+	outDir string,
+) (restored []types.ManifestFile, err error) {
+	response, err := c.get(ctx, fmt.Sprintf("%s/batches/%d/archive", c.baseURL, batchID))
+	if err != nil {
+		err = fmt.Errorf("%w: error sending GET /batches/%d/archive request: %w", errFailedDownload, batchID, err)
+
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	tarReader := tar.NewReader(response.Body)
+	manifest, err := c.readManifest(tarReader, root, batchID)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	outPaths, err := restorePaths(outDir, manifest.Files, c.overwrite)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	hasher := hash.NewSha256()
+	for i, file := range manifest.Files {
+		var content []byte
+		content, err = readTarEntry(tarReader, file.Name)
+		if err != nil {
+			err = fmt.Errorf("%w: error reading archive entry #%d: %s", errFailedDownload, file.Index, err)
+
+			return
+		}
+
+		fileLeaf := merkle.FileLeaf{Path: file.Name, Content: content, Mode: file.Mode, ModTime: file.ModTime}
+
+		var verified bool
+		verified, err = file.MerkleProof.Verify(manifest.LeafFormat.Data(fileLeaf, hasher), root.Hash, hasher)
+		if err != nil || !verified {
+			err = fmt.Errorf("%w: merkle root does not match for %s at index #%d", errFailedProveHash, file.Name, file.Index)
+
+			return
+		}
+
+		var mode uint32
+		var modTime int64
+		if manifest.LeafFormat == merkle.LeafFormatMetadataAttrs {
+			mode, modTime = file.Mode, file.ModTime
+		}
+
+		if err = WriteFile(outPaths[i], content, mode, modTime, c.overwrite); err != nil {
+			err = fmt.Errorf("%w: error writing %s: %w", errFailedDownload, file.Name, err)
+
+			return
+		}
+
+		restored = append(restored, file)
+	}
+
+	return
+}
+
+// WriteFile writes the content to the output path through a temporary file of the same directory,
+// so a failed write never leaves a partial file behind, along with the committed mode and
+// modification time if any. An existing file is only replaced if overwrite is set, the error is
+// os.ErrExist otherwise.
+func WriteFile(outPath string, content []byte, mode uint32, modTime int64, overwrite bool) (err error) {
+	if err = os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".*")
+	if err != nil {
+		return
+	}
+	defer func() { _ = os.Remove(tempFile.Name()) }()
+
+	_, err = tempFile.Write(content)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	perm := os.FileMode(0644)
+	if mode != 0 {
+		perm = os.FileMode(mode).Perm()
+	}
+	if err = os.Chmod(tempFile.Name(), perm); err != nil {
+		return
+	}
+
+	if modTime != 0 {
+		modificationTime := time.Unix(modTime, 0)
+		if err = os.Chtimes(tempFile.Name(), modificationTime, modificationTime); err != nil {
+			return
+		}
+	}
+
+	if overwrite {
+		return os.Rename(tempFile.Name(), outPath)
+	}
+
+	// unlike a rename, a link fails if the output path exists, and never replaces it.
+	if err = os.Link(tempFile.Name(), outPath); errors.Is(err, os.ErrExist) {
+		err = fmt.Errorf("%w: %s", os.ErrExist, outPath)
+	}
+
+	return
+}
+
+// readManifest reads the manifest at the start of a batch archive and makes sure it commits to
+// the expected batch and root, signed by the pinned public key if there is one.
+func (c *Client) readManifest(tarReader *tar.Reader, root Root, batchID int) (manifest types.BatchManifest, err error) {
+	manifestJson, err := readTarEntry(tarReader, types.ManifestFilename)
+	if err != nil {
+		err = fmt.Errorf("error reading archive manifest: %s", err)
+
+		return
+	}
+
+	if err = json.Unmarshal(manifestJson, &manifest); err != nil {
+		err = fmt.Errorf("error decoding archive manifest: %s", err)
+
+		return
+	}
+
+	if manifest.TreeHead.BatchID != batchID {
+		err = fmt.Errorf("archive belongs to batch %d, expected %d", manifest.TreeHead.BatchID, batchID)
+
+		return
+	}
+
+	if manifest.TreeHead.Root != hex.EncodeToString(root.Hash) {
+		err = fmt.Errorf(
+			"%w: archive belongs to the tree with root %s, expected %s",
+			errRootMismatch,
+			manifest.TreeHead.Root,
+			hex.EncodeToString(root.Hash),
+		)
+
+		return
+	}
+
+	if len(manifest.Files) != manifest.TreeHead.Size {
+		err = fmt.Errorf("archive lists %d files, tree size is %d", len(manifest.Files), manifest.TreeHead.Size)
+
+		return
+	}
+
+	if c.publicKey != nil {
+		if err = manifest.TreeHead.Verify(c.publicKey); err != nil {
+			err = fmt.Errorf("%w: %s", errUnsignedRoot, err)
+		}
+	}
+
+	return
+}
+
+// restorePaths returns the local paths the archived files are restored to, refusing names which
+// would escape the output directory or overwrite each other, or existing files unless overwrite is
+// set, before any file is restored.
+func restorePaths(outDir string, files []types.ManifestFile, overwrite bool) ([]string, error) {
+	outPaths := make([]string, len(files))
+	seen := make(map[string]bool, len(files))
+	for i, file := range files {
+		name := filepath.FromSlash(file.Name)
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("unsafe file name %q at index #%d", file.Name, file.Index)
+		}
+
+		outPath := filepath.Join(outDir, name)
+		if seen[outPath] {
+			return nil, fmt.Errorf("file name %q is used more than once", file.Name)
+		}
+
+		if _, err := os.Lstat(outPath); !overwrite && err == nil {
+			return nil, fmt.Errorf("%w: %s", os.ErrExist, outPath)
+		}
+
+		seen[outPath] = true
+		outPaths[i] = outPath
+	}
+
+	return outPaths, nil
+}
+
+func readTarEntry(tarReader *tar.Reader, expectedName string) ([]byte, error) {
+	header, err := tarReader.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("archive ended before %s", expectedName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Name != expectedName {
+		return nil, fmt.Errorf("unexpected entry %s, expected %s", header.Name, expectedName)
+	}
+
+	return io.ReadAll(tarReader)
+}
@@ -0,0 +1,42 @@
+package fxmerkle
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileRefusesExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "sub", "file")
+
+	if err := WriteFile(outPath, []byte("first"), 0, 0, false); err != nil {
+		t.Fatalf("unable to write the file: %s", err)
+	}
+
+	if err := WriteFile(outPath, []byte("second"), 0, 0, false); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("the existing file is written over: %v", err)
+	}
+	if content, _ := os.ReadFile(outPath); string(content) != "first" {
+		t.Fatalf("the refused write changed the file to %q", content)
+	}
+
+	if err := WriteFile(outPath, []byte("third"), 0o600, 0, true); err != nil {
+		t.Fatalf("unable to overwrite the file: %s", err)
+	}
+	if content, _ := os.ReadFile(outPath); string(content) != "third" {
+		t.Fatalf("the overwritten file is %q", content)
+	}
+
+	info, err := os.Stat(outPath)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("the overwritten file has mode %v: %v", info.Mode(), err)
+	}
+
+	// the temporary files are gone, written or not.
+	entries, err := os.ReadDir(filepath.Dir(outPath))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("the writes left %d files: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,71 @@
+package fxmerkle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// ListBatches returns the stored batches, oldest first.
+func (c *Client) ListBatches(ctx context.Context) ([]types.BatchResponse, error) {
+	var response types.BatchesResponse
+	if err := c.getJson(ctx, fmt.Sprintf("%s/batches", c.baseURL), &response); err != nil {
+		return nil, fmt.Errorf("%w: %w", errFailedBatchRequest, err)
+	}
+
+	return response.Batches, nil
+}
+
+// GetBatch describes the batch along with its files.
+func (c *Client) GetBatch(ctx context.Context, batchID int) (batch types.BatchResponse, err error) {
+	if err = c.getJson(ctx, fmt.Sprintf("%s/batches/%d", c.baseURL, batchID), &batch); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedBatchRequest, err)
+	}
+
+	return
+}
+
+// ListFiles returns a page of the files of the batch, the last batch if zero, starting from the
+// cursor of the previous page, or from the first file if empty.
+func (c *Client) ListFiles(ctx context.Context, batchID int, cursor string, limit int) (page types.FilesResponse, err error) {
+	query := url.Values{}
+	if batchID != 0 {
+		query.Set(types.QueryBatch, strconv.Itoa(batchID))
+	}
+	if cursor != "" {
+		query.Set(types.QueryCursor, cursor)
+	}
+	if limit != 0 {
+		query.Set(types.QueryLimit, strconv.Itoa(limit))
+	}
+
+	requestURL := fmt.Sprintf("%s/files", c.baseURL)
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	if err = c.getJson(ctx, requestURL, &page); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedBatchRequest, err)
+	}
+
+	return
+}
+
+// DeleteBatch deletes the batch along with its files.
+func (c *Client) DeleteBatch(ctx context.Context, batchID int) error {
+	response, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/batches/%d", c.baseURL, batchID), nil, nil)
+	if err != nil {
+		return fmt.Errorf("%w: error sending DELETE /batches/%d request: %w", errFailedBatchRequest, batchID, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusNoContent); err != nil {
+		return fmt.Errorf("%w: %w", errFailedBatchRequest, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package fxmerkle is the client of the fxmerkle server: it uploads files and verifies the merkle
+// root the server commits to, then downloads files, archives and dag paths and only hands out
+// their content once verified against that root.
+//
+//	client := fxmerkle.New("https://files.example.com", fxmerkle.WithAPIKey(apiKey))
+//	files, treeHead, err := client.Upload(ctx, []fxmerkle.File{fxmerkle.BytesFile("a.txt", content)})
+//	...
+//	root, err := fxmerkle.RootFromTreeHead(treeHead)
+//	info, err := client.Download(ctx, root, files[0].Index, destination)
+//
+// Errors can be told apart with errors.Is against ErrNotFound, ErrUnauthorized, ErrBadRequest,
+// ErrServerError and ErrVerificationFailed.
+package fxmerkle
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// Client uploads and downloads files to and from a fxmerkle server, it is safe for concurrent use.
+type Client struct {
+	httpClient  *http.Client
+	baseURL     string
+	apiKey      string
+	publicKey   ed25519.PublicKey
+	retryPolicy RetryPolicy
+	progress    ProgressReporter
+	overwrite   bool
+}
+
+// Option configures the client.
+type Option func(*Client)
+
+// WithHTTPClient sends the requests through the http client, with its timeout and transport,
+// instead of the default one.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey sends the api key along with every request, for servers requiring one.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithPublicKey pins the server public key, uploads and downloads are refused unless the merkle
+// root carries a valid signature of the key.
+func WithPublicKey(publicKey ed25519.PublicKey) Option {
+	return func(c *Client) {
+		c.publicKey = publicKey
+	}
+}
+
+// WithOverwrite lets the downloads written to a directory replace the files already there, they
+// are refused otherwise.
+func WithOverwrite(overwrite bool) Option {
+	return func(c *Client) {
+		c.overwrite = overwrite
+	}
+}
+
+// New returns the client of the server at the base url, such as https://localhost:8080.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		httpClient:  http.DefaultClient,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		retryPolicy: DefaultRetryPolicy,
+		progress:    noProgress{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+
+	if c.progress == nil {
+		c.progress = noProgress{}
+	}
+
+	return c
+}
+
+// do sends the request with the api key and the header set, it is canceled along with the context.
+// GET and PUT requests and requests carrying an idempotency key are retried as per the retry
+// policy, the body, if any, is created anew for every attempt. A body which can't be created fails
+// the request for good.
+func (c *Client) do(
+	ctx context.Context,
+	method, requestURL string,
+	header http.Header,
+	newBody func() (io.Reader, error),
+) (*http.Response, error) {
+	retryable := method == http.MethodGet || method == http.MethodPut || header.Get(types.HeaderIdempotencyKey) != ""
+
+	return c.sendWithRetries(ctx, retryable, func() (*http.Response, error) {
+		var body io.Reader
+		if newBody != nil {
+			var err error
+			if body, err = newBody(); err != nil {
+				return nil, &requestBodyError{err: err}
+			}
+		}
+
+		request, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+		if err != nil {
+			// a streamed body is only closed by the transport once the request is sent.
+			if closer, ok := body.(io.Closer); ok {
+				_ = closer.Close()
+			}
+
+			return nil, err
+		}
+
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		if c.apiKey != "" {
+			request.Header.Set(types.HeaderAPIKey, c.apiKey)
+		}
+
+		return c.httpClient.Do(request)
+	})
+}
+
+// requestBodyError is the error of a request body which can't be created or read, such as a file
+// which can't be opened or read, no other attempt would do better.
+type requestBodyError struct {
+	err error
+}
+
+func (e *requestBodyError) Error() string {
+	return fmt.Sprintf("error reading request body: %s", e.err)
+}
+
+func (e *requestBodyError) Unwrap() error {
+	return e.err
+}
+
+// bodyReader reads the content of a streamed request body, its read errors are request body
+// errors, so they aren't taken for the network errors the transport fails the request with.
+type bodyReader struct {
+	reader io.Reader
+}
+
+func (r *bodyReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &requestBodyError{err: err}
+	}
+
+	return
+}
+
+// Close closes the reader of the content, if it can be closed.
+func (r *bodyReader) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// withBatch adds the batch query param to the request url, unless the batch is zero, which the
+// server takes for its last batch.
+func withBatch(requestURL string, batchID int) string {
+	if batchID == 0 {
+		return requestURL
+	}
+
+	separator := "?"
+	if strings.Contains(requestURL, "?") {
+		separator = "&"
+	}
+
+	return fmt.Sprintf("%s%s%s=%d", requestURL, separator, types.QueryBatch, batchID)
+}
+
+func (c *Client) get(ctx context.Context, requestURL string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, requestURL, nil, nil)
+}
+
+func (c *Client) getJson(ctx context.Context, requestURL string, decoded any) error {
+	content, err := c.getContent(ctx, requestURL)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(content, decoded); err != nil {
+		return fmt.Errorf("error decoding json response: %s", err)
+	}
+
+	return nil
+}
+
+func (c *Client) getContent(ctx context.Context, requestURL string) ([]byte, error) {
+	response, err := c.get(ctx, requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("error sending GET request: %w", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(response.Body)
+}
@@ -0,0 +1,190 @@
+package fxmerkle
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TxCorpi0x/file-upload-merkle/server"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+)
+
+// newTestServer starts a server storing the batches in memory and signing their tree heads, it
+// returns the url of the server along with the signing key. The requests go through the middleware
+// if any, to tamper with them.
+func newTestServer(t *testing.T, middlewares ...func(http.Handler) http.Handler) (string, ed25519.PublicKey) {
+	t.Helper()
+
+	signer, err := sth.NewSigner(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handler http.Handler = server.NewRouter(server.RouterConfig{
+		Repository: storage.NewInMemoryStorage(),
+		Signer:     signer,
+	})
+	for _, middleware := range middlewares {
+		handler = middleware(handler)
+	}
+
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+
+	return testServer.URL, signer.PublicKey()
+}
+
+// uploadTestBatch uploads the contents as a batch of files named by their position and returns
+// the root of the batch.
+func uploadTestBatch(t *testing.T, client *Client, contents ...string) Root {
+	t.Helper()
+
+	files := make([]File, len(contents))
+	for i, content := range contents {
+		files[i] = BytesFile(string(rune('a'+i)), []byte(content))
+	}
+
+	_, treeHead, err := client.Upload(context.Background(), files)
+	if err != nil {
+		t.Fatalf("unable to upload the batch: %s", err)
+	}
+
+	root, err := RootFromTreeHead(treeHead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestClientDownloadsFromTheBatchOfTheRoot(t *testing.T) {
+	serverURL, publicKey := newTestServer(t)
+	client := New(serverURL, WithPublicKey(publicKey))
+	ctx := context.Background()
+
+	first := uploadTestBatch(t, client, "first a", "first b")
+	second := uploadTestBatch(t, client, "second a", "second b", "second c")
+	if first.TreeHead.BatchID == second.TreeHead.BatchID {
+		t.Fatalf("both uploads are batch %d", first.TreeHead.BatchID)
+	}
+
+	var content bytes.Buffer
+	if _, err := client.Download(ctx, first, 1, &content); err != nil {
+		t.Fatalf("unable to download from the first batch: %s", err)
+	}
+	if content.String() != "first a" {
+		t.Fatalf("downloaded %q from the first batch", content.String())
+	}
+
+	outDir := t.TempDir()
+	if _, err := client.DownloadFilesTo(ctx, first, []int{1, 2}, outDir, 2); err != nil {
+		t.Fatalf("unable to download the files of the first batch: %s", err)
+	}
+	if written, _ := os.ReadFile(filepath.Join(outDir, "b")); string(written) != "first b" {
+		t.Fatalf("downloaded %q from the first batch", written)
+	}
+
+	// a root kept without its signed tree head is verified against the tree head of its batch.
+	unsigned := first
+	unsigned.TreeHead = &sth.SignedTreeHead{TreeHead: first.TreeHead.TreeHead}
+	content.Reset()
+	if _, err := client.Download(ctx, unsigned, 2, &content); err != nil || content.String() != "first b" {
+		t.Fatalf("unable to download from the first batch with an unsigned tree head: %v", err)
+	}
+
+	// the root of the last batch is still verified without a tree head.
+	content.Reset()
+	if _, err := client.Download(ctx, Root{Hash: second.Hash}, 3, &content); err != nil || content.String() != "second c" {
+		t.Fatalf("unable to download from the last batch: %v", err)
+	}
+}
+
+func TestClientRejectsTheProofOfAnotherLeaf(t *testing.T) {
+	// the server answers the download of the first file with the second one and its proof.
+	serverURL, _ := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/download/1" {
+				r.URL.Path = "/download/2"
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+	client := New(serverURL)
+	root := uploadTestBatch(t, client, "a", "b")
+
+	var content bytes.Buffer
+	if _, err := client.Download(context.Background(), root, 1, &content); !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("the file at index 2 is accepted for index 1: %v", err)
+	}
+	if content.Len() != 0 {
+		t.Fatalf("the rejected file is written: %q", content.String())
+	}
+}
+
+// failingReader reads the content, then fails instead of ending.
+type failingReader struct {
+	content []byte
+	err     error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.content) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+
+	return n, nil
+}
+
+func TestClientDoesNotRetryFailingFileReads(t *testing.T) {
+	for name, concurrency := range map[string]int{"multipart": 1, "upload session": 2} {
+		t.Run(name, func(t *testing.T) {
+			var attempts atomic.Int32
+			serverURL, _ := newTestServer(t, func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodPost && r.URL.Path == "/upload" || r.Method == http.MethodPut {
+						attempts.Add(1)
+					}
+
+					next.ServeHTTP(w, r)
+				})
+			})
+			client := New(serverURL)
+
+			// the file is read once as it is hashed, then fails as it is sent.
+			readErr := errors.New("read failed")
+			var opened atomic.Int32
+			failing := File{Name: "failing", Open: func() (io.ReadCloser, error) {
+				content := bytes.Repeat([]byte("x"), 64<<10)
+				if opened.Add(1) == 1 {
+					return io.NopCloser(bytes.NewReader(content)), nil
+				}
+
+				return io.NopCloser(&failingReader{content: content[:1024], err: readErr}), nil
+			}}
+
+			files := []File{BytesFile("a", []byte("a")), failing}
+			if _, _, err := client.Upload(context.Background(), files, WithConcurrency(concurrency)); !errors.Is(err, readErr) {
+				t.Fatalf("upload of a failing file returned %v", err)
+			}
+			if opened.Load() != 2 {
+				t.Fatalf("the failing file is opened %d times, the read error is retried", opened.Load())
+			}
+			if attempts.Load() > 2 {
+				t.Fatalf("the upload is sent %d times", attempts.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package fxmerkle
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// DownloadDagPath verifies the path of the batch dag committed to by the tree head of the root and
+// returns its entry, along with its listing if it is a directory. The content of a file is written
+// to the destination once verified.
+func (c *Client) DownloadDagPath(
+	ctx context.Context,
+	root Root,
+	nodePath string,
+	destination io.Writer,
+) (target dag.Entry, listing []dag.Entry, err error) {
+	if root.TreeHead == nil {
+		err = fmt.Errorf("%w: the dag root is committed to by the tree head, which the root lacks", errFailedDownload)
+
+		return
+	}
+
+	treeHead := *root.TreeHead
+	if treeHead.DagRoot == "" {
+		err = fmt.Errorf("%w: batch %d was uploaded without a dag", errFailedDownload, treeHead.BatchID)
+
+		return
+	}
+
+	dagRootHash, err := c.verifyDagRoot(root)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errUnsignedRoot, err)
+
+		return
+	}
+
+	dagURL := fmt.Sprintf("%s/batches/%d/dag", c.baseURL, treeHead.BatchID)
+	if nodePath != "" {
+		dagURL += "/" + escapePath(nodePath)
+	}
+
+	var pathResponse types.DagPathResponse
+	if err = c.getJson(ctx, dagURL, &pathResponse); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	if pathResponse.Proof.Path != nodePath {
+		err = fmt.Errorf("%w: proof is for %q, expected %q", errFailedProveHash, pathResponse.Proof.Path, nodePath)
+
+		return
+	}
+
+	hasher := hash.NewSha256()
+	target, listing, err = pathResponse.Proof.Verify(dagRootHash, hasher)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errFailedProveHash, err)
+
+		return
+	}
+
+	if target.Kind != dag.KindFile {
+		return
+	}
+
+	content, err := c.getContent(ctx, fmt.Sprintf("%s?%s=true", dagURL, types.QueryContent))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	if err = dag.VerifyFile(target, content, hasher); err != nil {
+		err = fmt.Errorf("%w: %s", errFailedProveHash, err)
+
+		return
+	}
+
+	if _, err = destination.Write(content); err != nil {
+		err = fmt.Errorf("%w: error writing file content: %s", errFailedDownload, err)
+	}
+
+	return
+}
+
+// verifyDagRoot makes sure the tree head belongs to the merkle root, is signed by the pinned
+// public key if there is one, then returns the dag root hash it commits to.
+func (c *Client) verifyDagRoot(root Root) (hash.Hash, error) {
+	treeHead := root.TreeHead
+	if treeHead.Root != hex.EncodeToString(root.Hash) {
+		return nil, fmt.Errorf("tree head root %s does not match the merkle root %s", treeHead.Root, hex.EncodeToString(root.Hash))
+	}
+
+	if c.publicKey != nil {
+		if err := treeHead.Verify(c.publicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return dag.ParseCID(treeHead.DagRoot)
+}
+
+// escapePath escapes every component of the slash separated path for use in a url.
+func escapePath(nodePath string) string {
+	return (&url.URL{Path: nodePath}).EscapedPath()
+}
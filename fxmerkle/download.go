@@ -0,0 +1,290 @@
+package fxmerkle
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// Root is the merkle root downloads are verified against, as kept at upload time, along with the
+// tree head committing to it if it was kept too.
+type Root struct {
+	Hash     hash.Hash
+	TreeHead *sth.SignedTreeHead
+}
+
+// RootFromTreeHead returns the root the tree head commits to.
+func RootFromTreeHead(treeHead sth.SignedTreeHead) (Root, error) {
+	rootHash, err := hex.DecodeString(treeHead.Root)
+	if err != nil {
+		return Root{}, fmt.Errorf("error decoding tree head root: %s", err)
+	}
+
+	return Root{Hash: rootHash, TreeHead: &treeHead}, nil
+}
+
+// batchID returns the batch the root belongs to, 0 for the last batch if it has no tree head.
+func (r Root) batchID() int {
+	if r.TreeHead == nil {
+		return 0
+	}
+
+	return r.TreeHead.BatchID
+}
+
+// FileInfo describes a downloaded file as committed to by the tree.
+type FileInfo struct {
+	Index int
+	// Name is the name sent by the server, the tree only commits to it if NameVerified is set, in
+	// the metadata leaf formats.
+	Name         string
+	NameVerified bool
+	Size         int64
+	Mode         uint32
+	ModTime      int64
+}
+
+// Download verifies the file at the index of the batch of the root, of the last batch if the root
+// has no tree head, against the root and writes its content to the destination, nothing is
+// written unless the file is verified. If a public key is pinned, the root must be signed by it.
+func (c *Client) Download(ctx context.Context, root Root, index int, destination io.Writer) (info FileInfo, err error) {
+	c.progress.TransferStarted(1, -1)
+	defer func() { c.progress.TransferFinished(err) }()
+
+	if c.publicKey != nil {
+		if err = c.verifyRootSignature(ctx, root); err != nil {
+			err = fmt.Errorf("%w: %w", errUnsignedRoot, err)
+
+			return
+		}
+	}
+
+	fileContent, info, err := c.download(ctx, root, index)
+	c.progress.FileFinished(FileTransfer{Index: index, Name: info.Name, Bytes: info.Size, Size: info.Size}, err)
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(destination, bytes.NewReader(fileContent)); err != nil {
+		err = fmt.Errorf("%w: error writing downloaded file: %w", errFailedDownload, err)
+	}
+
+	return
+}
+
+// download downloads the file at the index of the batch of the root along with its proof and
+// verifies it against the root, the root signature is left to the caller.
+func (c *Client) download(ctx context.Context, root Root, index int) (fileContent []byte, info FileInfo, err error) {
+	downloadResponse, err := c.get(
+		ctx,
+		withBatch(fmt.Sprintf("%s/download/%d?%s=true", c.baseURL, index, types.QueryWithProof), root.batchID()),
+	)
+	if err != nil {
+		err = fmt.Errorf("%w: error sending GET /download request: %w", errFailedDownload, err)
+
+		return
+	}
+	defer func() { _ = downloadResponse.Body.Close() }()
+
+	if err = checkStatus(downloadResponse, http.StatusOK); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	fileContent, err = io.ReadAll(c.progressBody(downloadResponse, index))
+	if err != nil {
+		err = fmt.Errorf("%w: error reading download response body %w", errFailedDownload, err)
+
+		return
+	}
+
+	merkleProof, err := proofFromHeaders(downloadResponse.Header, root)
+	if errors.Is(err, errMissingProofHeaders) {
+		// servers which don't send the proof along with the content need a second round trip.
+		merkleProof, err = c.Proof(ctx, root.batchID(), index)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	// a valid proof of another leaf would pass the file at that leaf for the one asked for.
+	if merkleProof.Index != uint64(index-1) {
+		err = fmt.Errorf("%w: proof proves leaf %d, expected %d", errFailedProveHash, merkleProof.Index, index-1)
+
+		return
+	}
+
+	// the leaf commits to the file name and attributes sent along, as well as to the content.
+	leaf, info, err := leafFromHeaders(downloadResponse.Header, fileContent)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errFailedDownload, err)
+
+		return
+	}
+
+	verified, err := merkleProof.Verify(leaf, root.Hash, hash.NewSha256())
+	if err != nil || !verified {
+		err = fmt.Errorf("%w: merkle root does not match: %s", errFailedProveHash, hex.EncodeToString(root.Hash))
+
+		return
+	}
+
+	info.Index = index
+
+	return
+}
+
+// progressBody returns the body of the download response of the file at the index, reporting the
+// progress of the download.
+func (c *Client) progressBody(downloadResponse *http.Response, index int) io.Reader {
+	return newProgressReader(downloadResponse.Body, FileTransfer{
+		Index: index,
+		Name:  downloadResponse.Header.Get(types.HeaderFileName),
+		Size:  downloadResponse.ContentLength,
+	}, c.progress)
+}
+
+// leafFromHeaders returns the tree leaf data of the downloaded file, in the leaf format and with
+// the file metadata sent in the download response headers.
+func leafFromHeaders(header http.Header, fileContent []byte) (leaf []byte, info FileInfo, err error) {
+	leafFormat, err := merkle.ParseLeafFormat(header.Get(types.HeaderMerkleLeafFormat))
+	if err != nil {
+		return
+	}
+
+	fileLeaf := merkle.FileLeaf{Path: header.Get(types.HeaderFileName), Content: fileContent}
+	if mode := header.Get(types.HeaderFileMode); mode != "" {
+		var parsedMode uint64
+		parsedMode, err = strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			err = fmt.Errorf("error parsing %s header: %s", types.HeaderFileMode, err)
+
+			return
+		}
+
+		fileLeaf.Mode = uint32(parsedMode)
+	}
+
+	if modTime := header.Get(types.HeaderFileModTime); modTime != "" {
+		fileLeaf.ModTime, err = strconv.ParseInt(modTime, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("error parsing %s header: %s", types.HeaderFileModTime, err)
+
+			return
+		}
+	}
+
+	info = FileInfo{
+		Name:         fileLeaf.Path,
+		NameVerified: leafFormat != merkle.LeafFormatContent,
+		Size:         int64(len(fileContent)),
+		Mode:         fileLeaf.Mode,
+		ModTime:      fileLeaf.ModTime,
+	}
+
+	return leafFormat.Data(fileLeaf, hash.NewSha256()), info, nil
+}
+
+// proofFromHeaders parses the merkle proof sent in the download response headers and makes sure
+// it belongs to the expected root.
+func proofFromHeaders(header http.Header, root Root) (merkleProof merkle.Proof, err error) {
+	headerRoot := header.Get(types.HeaderMerkleRoot)
+	if headerRoot == "" {
+		err = errMissingProofHeaders
+
+		return
+	}
+
+	if headerRoot != hex.EncodeToString(root.Hash) {
+		err = fmt.Errorf(
+			"%w: file belongs to the tree with root %s, expected %s",
+			errRootMismatch, headerRoot, hex.EncodeToString(root.Hash),
+		)
+
+		return
+	}
+
+	merkleProof.Index, err = strconv.ParseUint(header.Get(types.HeaderMerkleIndex), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("error parsing %s header: %s", types.HeaderMerkleIndex, err)
+
+		return
+	}
+
+	if proofHeader := header.Get(types.HeaderMerkleProof); proofHeader != "" {
+		for _, proofHash := range strings.Split(proofHeader, ",") {
+			var decodedHash []byte
+			decodedHash, err = hex.DecodeString(proofHash)
+			if err != nil {
+				err = fmt.Errorf("error parsing %s header: %s", types.HeaderMerkleProof, err)
+
+				return
+			}
+
+			merkleProof.Hashes = append(merkleProof.Hashes, decodedHash)
+		}
+	}
+
+	return
+}
+
+// Proof returns the merkle proof of the file at the index of the batch, of the last batch if zero,
+// as sent by the server. It is up to the caller to verify it.
+func (c *Client) Proof(ctx context.Context, batchID, index int) (merkle.Proof, error) {
+	var proofResponse types.MerkleProofResponse
+	if err := c.getJson(ctx, withBatch(fmt.Sprintf("%s/proof/%d", c.baseURL, index), batchID), &proofResponse); err != nil {
+		return merkle.Proof{}, fmt.Errorf("error fetching merkle proof: %w", err)
+	}
+
+	return proofResponse.MerkleProof, nil
+}
+
+// TreeHead returns the tree head of the batch, of the last batch if zero, as sent by the server. It
+// is up to the caller to verify its signature.
+func (c *Client) TreeHead(ctx context.Context, batchID int) (sth.SignedTreeHead, error) {
+	var treeHeadResponse types.TreeHeadResponse
+	if err := c.getJson(ctx, withBatch(fmt.Sprintf("%s/sth", c.baseURL), batchID), &treeHeadResponse); err != nil {
+		return sth.SignedTreeHead{}, fmt.Errorf("error fetching tree head: %w", err)
+	}
+
+	return treeHeadResponse.TreeHead, nil
+}
+
+// verifyRootSignature makes sure the root hash is signed by the pinned public key, through the
+// tree head of the root if signed, or else the tree head the server signed for the batch of the
+// root, its last batch if the root has no tree head.
+func (c *Client) verifyRootSignature(ctx context.Context, root Root) error {
+	treeHead := root.TreeHead
+	if treeHead == nil || !treeHead.IsSigned() {
+		serverTreeHead, err := c.TreeHead(ctx, root.batchID())
+		if err != nil {
+			return err
+		}
+
+		treeHead = &serverTreeHead
+	}
+
+	if err := treeHead.Verify(c.publicKey); err != nil {
+		return err
+	}
+
+	if treeHead.Root != hex.EncodeToString(root.Hash) {
+		return fmt.Errorf("signed root %s does not match %s", treeHead.Root, hex.EncodeToString(root.Hash))
+	}
+
+	return nil
+}
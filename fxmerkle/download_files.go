@@ -0,0 +1,300 @@
+package fxmerkle
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// DownloadedFile is the outcome of the download of a file by DownloadFilesTo, the path it is
+// written to if it is verified, or the error it failed with.
+type DownloadedFile struct {
+	FileInfo
+	Path string
+	Err  error
+}
+
+// DownloadFilesTo downloads the files at the indexes of the batch of the root, of the last batch if
+// the root has no tree head, at most jobs at a time, verifies every file against the root and
+// writes it under its name in the output directory, only once verified. The files are proven at once by a multi-proof if the server supports it, by
+// the proof sent along with each file otherwise. The outcome of every file is returned, sorted by
+// index, along with the errors of the failed ones joined. If a public key is pinned, the root must
+// be signed by it.
+func (c *Client) DownloadFilesTo(
+	ctx context.Context,
+	root Root,
+	indexes []int,
+	outDir string,
+	jobs int,
+) ([]DownloadedFile, error) {
+	indexes = slices.Clone(indexes)
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	downloaded := make([]DownloadedFile, len(indexes))
+	for i, index := range indexes {
+		downloaded[i].Index = index
+	}
+
+	c.progress.TransferStarted(len(indexes), -1)
+
+	// a failure common to every file is reported for each of them.
+	failAll := func(err error) ([]DownloadedFile, error) {
+		for i := range downloaded {
+			downloaded[i].Err = err
+		}
+		c.progress.TransferFinished(err)
+
+		return downloaded, err
+	}
+
+	if indexes[0] < 1 {
+		return failAll(fmt.Errorf("%w: indexes start from 1", errFailedDownload))
+	}
+
+	if c.publicKey != nil {
+		if err := c.verifyRootSignature(ctx, root); err != nil {
+			return failAll(fmt.Errorf("%w: %w: %w", errFailedDownload, errUnsignedRoot, err))
+		}
+	}
+
+	leafHashes, err := c.verifiedLeafHashes(ctx, root, indexes)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return failAll(fmt.Errorf("%w: %w", errFailedDownload, err))
+	}
+	// without a multi-proof, from servers which don't serve them or as some index isn't stored,
+	// every file is downloaded along with its own proof.
+
+	w := fileWriter{outDir: outDir, overwrite: c.overwrite, written: make(map[string]int)}
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(min(jobs, len(indexes)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range work {
+				downloaded[i] = c.downloadFileTo(ctx, root, indexes[i], leafHashes, &w)
+			}
+		}()
+	}
+
+	for i := range indexes {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var errs []error
+	for _, file := range downloaded {
+		if file.Err != nil {
+			errs = append(errs, file.Err)
+		}
+	}
+
+	err = errors.Join(errs...)
+	c.progress.TransferFinished(err)
+
+	return downloaded, err
+}
+
+// downloadFileTo downloads and verifies the file at the index, against its leaf hash if proven by
+// the multi-proof, then writes it to the output directory.
+func (c *Client) downloadFileTo(
+	ctx context.Context,
+	root Root,
+	index int,
+	leafHashes map[int]hash.Hash,
+	w *fileWriter,
+) (downloaded DownloadedFile) {
+	var content []byte
+	var err error
+	if leafHash, found := leafHashes[index]; found {
+		content, downloaded.FileInfo, err = c.downloadLeaf(ctx, root.batchID(), index, leafHash)
+	} else {
+		content, downloaded.FileInfo, err = c.download(ctx, root, index)
+	}
+	downloaded.Index = index
+	defer func() {
+		c.progress.FileFinished(FileTransfer{
+			Index: index,
+			Name:  downloaded.Name,
+			Bytes: downloaded.Size,
+			Size:  downloaded.Size,
+		}, downloaded.Err)
+	}()
+
+	if err != nil {
+		downloaded.Err = fmt.Errorf("file at index #%d: %w", index, err)
+
+		return
+	}
+
+	downloaded.Path, err = w.write(downloaded.FileInfo, content)
+	if err != nil {
+		downloaded.Err = fmt.Errorf("%w: file at index #%d: %w", errFailedDownload, index, err)
+	}
+
+	return
+}
+
+// downloadLeaf downloads the file at the index of the batch, without proof, and makes sure it is
+// the leaf of the leaf hash.
+func (c *Client) downloadLeaf(ctx context.Context, batchID, index int, leafHash hash.Hash) (fileContent []byte, info FileInfo, err error) {
+	downloadResponse, err := c.get(ctx, withBatch(fmt.Sprintf("%s/download/%d", c.baseURL, index), batchID))
+	if err != nil {
+		err = fmt.Errorf("%w: error sending GET /download request: %w", errFailedDownload, err)
+
+		return
+	}
+	defer func() { _ = downloadResponse.Body.Close() }()
+
+	if err = checkStatus(downloadResponse, http.StatusOK); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedDownload, err)
+
+		return
+	}
+
+	fileContent, err = io.ReadAll(c.progressBody(downloadResponse, index))
+	if err != nil {
+		err = fmt.Errorf("%w: error reading download response body %w", errFailedDownload, err)
+
+		return
+	}
+
+	// the leaf commits to the file name and attributes sent along, as well as to the content.
+	leaf, info, err := leafFromHeaders(downloadResponse.Header, fileContent)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errFailedDownload, err)
+
+		return
+	}
+
+	if !bytes.Equal(hash.NewSha256().Hash(leaf), leafHash) {
+		err = fmt.Errorf("%w: file does not match the proven leaf %s", errFailedProveHash, hex.EncodeToString(leafHash))
+
+		return
+	}
+
+	info.Index = index
+
+	return
+}
+
+// MultiProof returns the multi-proof of the files at the indexes of the batch, of the last batch if
+// zero, along with their leaf hashes, as sent by the server. It is up to the caller to verify it.
+func (c *Client) MultiProof(ctx context.Context, batchID int, indexes []int) (types.MultiProofResponse, error) {
+	query := url.Values{}
+	for _, index := range indexes {
+		query.Add(types.QueryIndex, strconv.Itoa(index))
+	}
+	if batchID != 0 {
+		query.Set(types.QueryBatch, strconv.Itoa(batchID))
+	}
+
+	var multiProofResponse types.MultiProofResponse
+	if err := c.getJson(ctx, fmt.Sprintf("%s/multiproof?%s", c.baseURL, query.Encode()), &multiProofResponse); err != nil {
+		return types.MultiProofResponse{}, fmt.Errorf("error fetching merkle multi-proof: %w", err)
+	}
+
+	return multiProofResponse, nil
+}
+
+// verifiedLeafHashes returns the leaf hashes of the files at the sorted indexes, by index, once
+// their multi-proof is verified against the root.
+func (c *Client) verifiedLeafHashes(ctx context.Context, root Root, indexes []int) (map[int]hash.Hash, error) {
+	multiProofResponse, err := c.MultiProof(ctx, root.batchID(), indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	if multiProofResponse.Root != hex.EncodeToString(root.Hash) {
+		return nil, fmt.Errorf(
+			"%w: files belong to the tree with root %s, expected %s",
+			errRootMismatch, multiProofResponse.Root, hex.EncodeToString(root.Hash),
+		)
+	}
+
+	multiProof := multiProofResponse.MultiProof
+	if len(multiProof.Indexes) != len(indexes) || len(multiProofResponse.LeafHashes) != len(indexes) {
+		return nil, fmt.Errorf("%w: multi-proof does not prove the %d files asked for", errFailedProveHash, len(indexes))
+	}
+
+	leafHashes := make(hash.HashList, len(indexes))
+	for i, index := range indexes {
+		// indexes start from 1 while tree leaves start from 0.
+		if multiProof.Indexes[i] != uint64(index-1) {
+			return nil, fmt.Errorf("%w: multi-proof proves leaf %d, expected %d", errFailedProveHash, multiProof.Indexes[i], index-1)
+		}
+
+		if leafHashes[i], err = hex.DecodeString(multiProofResponse.LeafHashes[i]); err != nil {
+			return nil, fmt.Errorf("error decoding multi-proof leaf hash: %s", err)
+		}
+	}
+
+	verified, err := multiProof.Verify(leafHashes, root.Hash, hash.NewSha256())
+	if err != nil || !verified {
+		return nil, fmt.Errorf("%w: merkle root does not match: %s", errFailedProveHash, hex.EncodeToString(root.Hash))
+	}
+
+	byIndex := make(map[int]hash.Hash, len(indexes))
+	for i, index := range indexes {
+		byIndex[index] = leafHashes[i]
+	}
+
+	return byIndex, nil
+}
+
+// fileWriter writes the downloaded files under their names in the output directory, refusing
+// names which would escape it or overwrite another downloaded file, or an existing file unless
+// overwrite is set.
+type fileWriter struct {
+	outDir    string
+	overwrite bool
+
+	mu      sync.Mutex
+	written map[string]int
+}
+
+func (w *fileWriter) write(info FileInfo, content []byte) (string, error) {
+	name := filepath.FromSlash(info.Name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("unsafe file name %q", info.Name)
+	}
+
+	outPath := filepath.Join(w.outDir, name)
+
+	w.mu.Lock()
+	writtenIndex, found := w.written[outPath]
+	if !found {
+		w.written[outPath] = info.Index
+	}
+	w.mu.Unlock()
+
+	if found {
+		return "", fmt.Errorf("file name %q is already used by the file at index #%d", info.Name, writtenIndex)
+	}
+
+	// attributes are only sent along when the leaf commits to them.
+	if err := WriteFile(outPath, content, info.Mode, info.ModTime, w.overwrite); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", info.Name, err)
+	}
+
+	return outPath, nil
+}
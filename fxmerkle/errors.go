@@ -0,0 +1,90 @@
+package fxmerkle
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The kinds of the errors returned by the client, to be checked with errors.Is.
+var (
+	// ErrNotFound is returned when the file, batch or dag path asked for is not stored.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when the api key is missing, unknown or not allowed to make the request.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrBadRequest is returned when the server rejects the request with any other 4xx status.
+	ErrBadRequest = errors.New("bad request")
+	// ErrServerError is returned when the server fails with a 5xx status or answers with any other
+	// unexpected status.
+	ErrServerError = errors.New("server error")
+	// ErrVerificationFailed is returned when what the server sent doesn't match the merkle root, the
+	// dag root or the signature it is checked against.
+	ErrVerificationFailed = errors.New("verification failed")
+)
+
+var (
+	errFailedDownload      = errors.New("failed to download file")
+	errFailedProveHash     = verificationError("failed to prove hash")
+	errFailedUpload        = errors.New("failed to upload files")
+	errRootMismatch        = verificationError("local merkle root does not match the server root")
+	errMissingProofHeaders = errors.New("merkle proof headers are missing from the download response")
+	errUnsignedRoot        = verificationError("merkle root carries no valid signature of the pinned key")
+	errFailedBatchRequest  = errors.New("failed batch request")
+	// errUploadSessionsUnsupported is returned by servers which don't open upload sessions.
+	errUploadSessionsUnsupported = errors.New("upload sessions are not supported by the server")
+)
+
+// verificationError is a failed verification, its message is kept as is.
+type verificationError string
+
+func (e verificationError) Error() string {
+	return string(e)
+}
+
+func (e verificationError) Unwrap() error {
+	return ErrVerificationFailed
+}
+
+// StatusError is an unexpected http status answered by the server, it is ErrNotFound,
+// ErrUnauthorized, ErrBadRequest or ErrServerError depending on the status.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	if e.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("not found: %s %s", e.Method, e.Path)
+	}
+
+	return fmt.Sprintf("unexpected http status for %s %s: %s", e.Method, e.Path, e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return target == ErrNotFound
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden:
+		return target == ErrUnauthorized
+	case e.StatusCode >= 400 && e.StatusCode < 500:
+		return target == ErrBadRequest
+	default:
+		return target == ErrServerError
+	}
+}
+
+// checkStatus returns a StatusError unless the response has the expected status.
+func checkStatus(response *http.Response, expectedStatusCode int) error {
+	if response.StatusCode == expectedStatusCode {
+		return nil
+	}
+
+	return &StatusError{
+		Method:     response.Request.Method,
+		Path:       response.Request.URL.Path,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+	}
+}
@@ -0,0 +1,52 @@
+package fxmerkle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStatusErrorKinds(t *testing.T) {
+	for statusCode, expected := range map[int]error{
+		http.StatusBadRequest:            ErrBadRequest,
+		http.StatusUnauthorized:          ErrUnauthorized,
+		http.StatusForbidden:             ErrUnauthorized,
+		http.StatusNotFound:              ErrNotFound,
+		http.StatusConflict:              ErrBadRequest,
+		http.StatusRequestEntityTooLarge: ErrBadRequest,
+		http.StatusUnprocessableEntity:   ErrBadRequest,
+		http.StatusInternalServerError:   ErrServerError,
+		http.StatusServiceUnavailable:    ErrServerError,
+		http.StatusOK:                    ErrServerError,
+	} {
+		err := error(&StatusError{Method: http.MethodGet, Path: "/", StatusCode: statusCode, Status: http.StatusText(statusCode)})
+		for _, kind := range []error{ErrNotFound, ErrUnauthorized, ErrBadRequest, ErrServerError} {
+			if errors.Is(err, kind) != (kind == expected) {
+				t.Errorf("status %d is %v: %t", statusCode, kind, errors.Is(err, kind))
+			}
+		}
+	}
+}
+
+func TestShouldRetryTransientStatusesOnly(t *testing.T) {
+	for statusCode, transient := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusRequestTimeout:      true,
+		http.StatusConflict:            false,
+		http.StatusUnprocessableEntity: false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusNotImplemented:      false,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	} {
+		if retried := shouldRetry(context.Background(), &http.Response{StatusCode: statusCode}, nil); retried != transient {
+			t.Errorf("status %d is retried: %t", statusCode, retried)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package fxmerkle
+
+import (
+	"io"
+)
+
+// FileTransfer describes a file being uploaded or downloaded.
+type FileTransfer struct {
+	// Index is the position of the file in the upload, from 1, or its index in the batch.
+	Index int
+	Name  string
+	// Bytes is the number of bytes of the file transferred so far.
+	Bytes int64
+	// Size is the file size, -1 if it is not known yet.
+	Size int64
+}
+
+// ProgressReporter is told about the progress of the uploads and downloads of the client. Its
+// methods are called concurrently when files are transferred concurrently, they must not block.
+type ProgressReporter interface {
+	// TransferStarted is called when the transfer of the files, totalling size bytes or -1 if it
+	// is not known, starts.
+	TransferStarted(files int, size int64)
+	// FileProgress is called as the file is transferred. A file sent again, such as when its
+	// request is retried, starts again from 0 bytes.
+	FileProgress(file FileTransfer)
+	// FileFinished is called once the file is transferred, and verified for downloads, or failed.
+	FileFinished(file FileTransfer, err error)
+	// TransferFinished is called once the transfer is done or failed.
+	TransferFinished(err error)
+}
+
+// WithProgress reports the progress of the uploads and downloads to the reporter.
+func WithProgress(reporter ProgressReporter) Option {
+	return func(c *Client) {
+		c.progress = reporter
+	}
+}
+
+// noProgress is the reporter of the clients created without one.
+type noProgress struct{}
+
+func (noProgress) TransferStarted(int, int64)       {}
+func (noProgress) FileProgress(FileTransfer)        {}
+func (noProgress) FileFinished(FileTransfer, error) {}
+func (noProgress) TransferFinished(error)           {}
+
+// progressReader reports the bytes of the file read through it.
+type progressReader struct {
+	reader   io.Reader
+	file     FileTransfer
+	reporter ProgressReporter
+}
+
+// newProgressReader returns a reader of the file reporting its progress from 0 bytes.
+func newProgressReader(reader io.Reader, file FileTransfer, reporter ProgressReporter) *progressReader {
+	file.Bytes = 0
+	reporter.FileProgress(file)
+
+	return &progressReader{reader: reader, file: file, reporter: reporter}
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.file.Bytes += int64(n)
+		r.reporter.FileProgress(r.file)
+	}
+
+	return
+}
+
+// Close closes the reader of the file, if it can be closed.
+func (r *progressReader) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
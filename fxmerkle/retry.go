@@ -0,0 +1,114 @@
+package fxmerkle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries the requests which fail with a network error or a transient http status,
+// waiting a random backoff, up to exponentially growing bounds, between the attempts. Only GET and
+// PUT requests, and requests carrying an idempotency key, are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent at most, it is not retried below 2.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of the clients created without one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// WithRetryPolicy sets the retry policy of the requests, RetryPolicy{} disables the retries.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// transientStatusCodes are the statuses of the requests which may succeed if sent again.
+var transientStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// shouldRetry tells whether the attempt failed in a way another attempt may not.
+func shouldRetry(ctx context.Context, response *http.Response, err error) bool {
+	if err != nil {
+		var bodyErr *requestBodyError
+		if errors.As(err, &bodyErr) {
+			return false
+		}
+
+		// the context ending is final, any other error is a network one.
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	return slices.Contains(transientStatusCodes, response.StatusCode)
+}
+
+// backoff returns the time to wait after the failed attempt, a random duration up to the bound of
+// the attempt, or the time the server asked to wait if it is longer, still capped by the maximum.
+func (p RetryPolicy) backoff(attempt int, response *http.Response) time.Duration {
+	bound := p.InitialBackoff << min(attempt-1, 30)
+	if bound <= 0 || bound > p.MaxBackoff {
+		bound = p.MaxBackoff
+	}
+
+	var wait time.Duration
+	if bound > 0 {
+		wait = rand.N(bound) + 1
+	}
+
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait = max(wait, min(time.Duration(seconds)*time.Second, p.MaxBackoff))
+		}
+	}
+
+	return wait
+}
+
+// sendWithRetries sends the request until it succeeds, fails for good or the attempts run out.
+// The body is created anew for every attempt.
+func (c *Client) sendWithRetries(
+	ctx context.Context,
+	retryable bool,
+	send func() (*http.Response, error),
+) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := send()
+		if !retryable || attempt >= c.retryPolicy.MaxAttempts || !shouldRetry(ctx, response, err) {
+			return response, err
+		}
+
+		wait := c.retryPolicy.backoff(attempt, response)
+		if response != nil {
+			// the connection is only reused once the body is read to the end.
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package fxmerkle
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/TxCorpi0x/file-upload-merkle/conf"
+)
+
+// NewTLSConfig returns the TLS config of the client. The server certificate is verified against
+// the CA bundle if one is given, the system roots otherwise, and the client certificate, if any,
+// is presented to servers requiring mutual TLS.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		rootCAs, err := conf.CertPoolFromFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,631 @@
+package fxmerkle
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// File is a file to upload, stored under its name, a relative slash separated path, on the server.
+// Its content is read each time it is opened, as the root is computed locally before the upload.
+type File struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+	// Mode and ModTime are committed to by the metadata+attrs leaf format only.
+	Mode    uint32
+	ModTime int64
+}
+
+// LocalFile returns the local file at the path, uploaded under the name along with its permission
+// bits and modification time.
+func LocalFile(path, name string) (File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Name:    name,
+		Open:    func() (io.ReadCloser, error) { return os.Open(path) },
+		Mode:    uint32(info.Mode().Perm()),
+		ModTime: info.ModTime().Unix(),
+	}, nil
+}
+
+// BytesFile returns a file of the content, uploaded under the name.
+func BytesFile(name string, content []byte) File {
+	return File{
+		Name: name,
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(content)), nil },
+	}
+}
+
+type uploadOptions struct {
+	ordering       types.Ordering
+	leafFormat     merkle.LeafFormat
+	withDag        bool
+	idempotencyKey string
+	concurrency    int
+}
+
+// UploadOption configures an upload.
+type UploadOption func(*uploadOptions)
+
+// WithOrdering sets the order in which the files are placed as tree leaves, the canonical path
+// ordering by default.
+func WithOrdering(ordering types.Ordering) UploadOption {
+	return func(o *uploadOptions) {
+		o.ordering = ordering
+	}
+}
+
+// WithLeafFormat sets the format of the data committed to by the tree leaves, the file content
+// only by default.
+func WithLeafFormat(leafFormat merkle.LeafFormat) UploadOption {
+	return func(o *uploadOptions) {
+		o.leafFormat = leafFormat
+	}
+}
+
+// WithDag makes the server build the directory structured dag of the files along with the tree,
+// its root is signed as part of the tree head.
+func WithDag(withDag bool) UploadOption {
+	return func(o *uploadOptions) {
+		o.withDag = withDag
+	}
+}
+
+// WithIdempotencyKey sets the idempotency key of the upload, a random one is generated otherwise.
+// The server answers an upload sent again with the same key with the response of the first one,
+// so the upload can be retried, even by another process, without storing the batch twice.
+func WithIdempotencyKey(key string) UploadOption {
+	return func(o *uploadOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithConcurrency sends the files one by one, up to n at a time, through an upload session rather
+// than in a single request. Servers without upload sessions get the single request. The files are
+// placed as tree leaves in the same order either way.
+func WithConcurrency(n int) UploadOption {
+	return func(o *uploadOptions) {
+		o.concurrency = n
+	}
+}
+
+// Upload uploads the files as a new batch, then rebuilds the tree, and the dag if asked for, locally
+// and makes sure the server committed to the same root in the returned tree head. If a public key
+// is pinned, the tree head must be signed by it.
+func (c *Client) Upload(ctx context.Context, files []File, opts ...UploadOption) (
+	uploadedFiles []types.UploadedFile,
+	treeHead sth.SignedTreeHead,
+	err error,
+) {
+	o := uploadOptions{ordering: types.OrderingPath, leafFormat: merkle.LeafFormatContent}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(files) == 0 {
+		err = fmt.Errorf("%w: no files to upload", errFailedUpload)
+
+		return
+	}
+
+	// the names are committed to as the server normalizes them.
+	files = slices.Clone(files)
+	for i := range files {
+		if files[i].Name, err = types.NormalizeFileName(files[i].Name); err != nil {
+			err = fmt.Errorf("%w: %s", errFailedUpload, err)
+
+			return
+		}
+	}
+
+	if o.idempotencyKey == "" {
+		if o.idempotencyKey, err = newIdempotencyKey(); err != nil {
+			err = fmt.Errorf("%w: error generating idempotency key: %s", errFailedUpload, err)
+
+			return
+		}
+	}
+
+	// the files are hashed as they are read, none of them is held in memory, then read again as
+	// they are sent.
+	digests, err := digestFiles(ctx, files, o)
+	if err != nil {
+		err = fmt.Errorf("%w: error hashing files: %s", errFailedUpload, err)
+
+		return
+	}
+
+	files, digests = orderFiles(files, digests, o.ordering)
+
+	var size int64
+	for _, digest := range digests {
+		size += digest.size
+	}
+
+	c.progress.TransferStarted(len(files), size)
+	defer func() { c.progress.TransferFinished(err) }()
+
+	var decodedResponse types.UploadedFilesResponse
+	if o.concurrency > 1 {
+		decodedResponse, err = c.uploadSession(ctx, files, digests, o)
+	}
+	if o.concurrency <= 1 || errors.Is(err, errUploadSessionsUnsupported) {
+		decodedResponse, err = c.uploadMultipart(ctx, files, digests, o)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errFailedUpload, err)
+
+		return
+	}
+
+	if decodedResponse.Ordering != o.ordering {
+		err = fmt.Errorf("%w: server ordered files by %q, expected %q", errFailedUpload, decodedResponse.Ordering, o.ordering)
+
+		return
+	}
+
+	if decodedResponse.LeafFormat != o.leafFormat {
+		err = fmt.Errorf(
+			"%w: server committed to %q leaves, expected %q",
+			errFailedUpload, decodedResponse.LeafFormat, o.leafFormat,
+		)
+
+		return
+	}
+
+	treeHead = decodedResponse.TreeHead
+	if treeHead.Root == "" {
+		err = fmt.Errorf("%w: server did not return a merkle root", errFailedUpload)
+
+		return
+	}
+
+	if _, err = computeMerkleRoot(files, digests, decodedResponse); err != nil {
+		err = fmt.Errorf("%w: error computing merkle root: %w", errFailedUpload, err)
+
+		return
+	}
+
+	if err = verifyDagRoot(files, digests, o.withDag, treeHead); err != nil {
+		err = fmt.Errorf("%w: %w", errFailedUpload, err)
+
+		return
+	}
+
+	if err = verifyTreeHead(treeHead, len(files), hash.NewSha256(), c.publicKey); err != nil {
+		err = fmt.Errorf("%w: %w: %s", errFailedUpload, ErrVerificationFailed, err)
+
+		return
+	}
+
+	return decodedResponse.UploadedFiles, treeHead, nil
+}
+
+// newIdempotencyKey returns a random idempotency key.
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// verifyDagRoot builds the dag of the files locally and makes sure its root is the one committed
+// to by the tree head, which must not commit to a dag if none was asked for.
+func verifyDagRoot(files []File, digests []fileDigest, withDag bool, treeHead sth.SignedTreeHead) error {
+	if !withDag {
+		if treeHead.DagRoot != "" {
+			return fmt.Errorf("%w: tree head commits to dag %s which was not asked for", ErrVerificationFailed, treeHead.DagRoot)
+		}
+
+		return nil
+	}
+
+	dagFiles := make([]dag.File, len(files))
+	for i, f := range files {
+		dagFiles[i] = dag.File{Path: f.Name, Hash: digests[i].dagHash, Size: digests[i].size}
+	}
+
+	dagRoot, err := dag.Build(dagFiles, hash.NewSha256())
+	if err != nil {
+		return fmt.Errorf("error building the dag: %s", err)
+	}
+
+	if dagRoot.CID() != treeHead.DagRoot {
+		return fmt.Errorf("%w: local dag root %s, server dag root %s", errRootMismatch, dagRoot.CID(), treeHead.DagRoot)
+	}
+
+	return nil
+}
+
+// verifyTreeHead makes sure the tree head describes the uploaded files and that it is signed by
+// the pinned public key. Without a pinned key, the signature is checked against the key sent along.
+func verifyTreeHead(treeHead sth.SignedTreeHead, size int, hasher hash.Hasher, publicKey ed25519.PublicKey) error {
+	if treeHead.Size != size {
+		return fmt.Errorf("tree head size %d does not match %d uploaded files", treeHead.Size, size)
+	}
+
+	if treeHead.Algorithm != hasher.Name() {
+		return fmt.Errorf("tree head algorithm %s is not supported, expected %s", treeHead.Algorithm, hasher.Name())
+	}
+
+	if publicKey != nil {
+		return treeHead.Verify(publicKey)
+	}
+
+	if treeHead.IsSigned() {
+		return treeHead.VerifyEmbedded()
+	}
+
+	return nil
+}
+
+// computeMerkleRoot rebuilds the merkle tree locally in the order the server indexed the files
+// and makes sure the resulting root is the same as the one returned by the server.
+func computeMerkleRoot(
+	files []File,
+	digests []fileDigest,
+	uploadResponse types.UploadedFilesResponse,
+) (merkleRoot string, err error) {
+	positions, err := uploadedPositions(files, digests, uploadResponse.UploadedFiles)
+	if err != nil {
+		return
+	}
+
+	orderedFiles := make([]File, len(positions))
+	leafHashes := make(hash.HashList, len(positions))
+	localHashes := make([]string, len(positions))
+	for i, position := range positions {
+		orderedFiles[i] = files[position]
+		leafHashes[i] = digests[position].leafHash
+		localHashes[i] = hex.EncodeToString(leafHashes[i])
+	}
+
+	merkleRoot = hex.EncodeToString(merkle.RootFromLeafHashes(leafHashes, hash.NewSha256()))
+	if merkleRoot != uploadResponse.TreeHead.Root {
+		err = fmt.Errorf(
+			"%w: local root %s, server root %s\n%s",
+			errRootMismatch,
+			merkleRoot,
+			uploadResponse.TreeHead.Root,
+			uploadDiff(orderedFiles, localHashes, uploadResponse.UploadedFiles),
+		)
+
+		return
+	}
+
+	return merkleRoot, nil
+}
+
+// uploadedPositions pairs every uploaded file returned by the server with the local file it was
+// read from, by name and leaf hash, and returns the positions of the local files sorted by the
+// server assigned index.
+func uploadedPositions(files []File, digests []fileDigest, uploadedFiles []types.UploadedFile) ([]int, error) {
+	if len(files) != len(uploadedFiles) {
+		return nil, fmt.Errorf("%d files were sent but the server indexed %d", len(files), len(uploadedFiles))
+	}
+
+	// files sent more than once under the same name are told apart by their leaf hash, the ones
+	// with the same leaf too are interchangeable.
+	uploadedKey := func(name, leafHash string) string { return name + "\x00" + leafHash }
+	positionsByKey := make(map[string][]int)
+	for position, f := range files {
+		key := uploadedKey(f.Name, hex.EncodeToString(digests[position].leafHash))
+		positionsByKey[key] = append(positionsByKey[key], position)
+	}
+
+	sortedFiles := make([]types.UploadedFile, len(uploadedFiles))
+	copy(sortedFiles, uploadedFiles)
+	sort.SliceStable(sortedFiles, func(i, j int) bool { return sortedFiles[i].Index < sortedFiles[j].Index })
+
+	positions := make([]int, len(sortedFiles))
+	for i, f := range sortedFiles {
+		// indexes start from 1 and map to the leaf position of the tree.
+		if f.Index != i+1 {
+			return nil, fmt.Errorf("unexpected index #%d for %s, expected #%d", f.Index, f.Name, i+1)
+		}
+
+		key := uploadedKey(f.Name, f.Hash)
+		sentPositions := positionsByKey[key]
+		if len(sentPositions) == 0 {
+			return nil, fmt.Errorf(
+				"%w: server returned unknown file %s with leaf hash %s at index #%d",
+				errRootMismatch, f.Name, f.Hash, f.Index,
+			)
+		}
+
+		positions[i] = sentPositions[0]
+		positionsByKey[key] = sentPositions[1:]
+	}
+
+	return positions, nil
+}
+
+// uploadDiff returns a human readable, per-file comparison between local and server leaf hashes.
+func uploadDiff(orderedFiles []File, localHashes []string, uploadedFiles []types.UploadedFile) string {
+	serverHashes := make(map[int]types.UploadedFile, len(uploadedFiles))
+	for _, f := range uploadedFiles {
+		serverHashes[f.Index] = f
+	}
+
+	var diff strings.Builder
+	for i, f := range orderedFiles {
+		serverFile := serverHashes[i+1]
+
+		status := "ok"
+		if serverFile.Hash != localHashes[i] {
+			status = "MISMATCH"
+		}
+
+		_, _ = fmt.Fprintf(&diff, "#%d %-8s local %s (%s) server %s (%s)\n",
+			i+1, status, localHashes[i], f.Name, serverFile.Hash, serverFile.Name)
+	}
+
+	return diff.String()
+}
+
+// fileDigest is what the tree leaf and the dag commit to of a file, computed while streaming it.
+type fileDigest struct {
+	leafHash hash.Hash
+	// dagHash is only computed when a dag is asked for.
+	dagHash hash.Hash
+	size    int64
+}
+
+// digestFiles reads every file once, up to the upload concurrency at a time, and returns their
+// digests in the same order.
+func digestFiles(ctx context.Context, files []File, o uploadOptions) ([]fileDigest, error) {
+	digests := make([]fileDigest, len(files))
+	err := forEach(ctx, len(files), o.concurrency, func(i int) (err error) {
+		if digests[i], err = digestFile(files[i], o); err != nil {
+			err = fmt.Errorf("%s: %w", files[i].Name, err)
+		}
+
+		return
+	})
+
+	return digests, err
+}
+
+// digestFile hashes the file content as the leaf format commits to it, along with its dag hash if
+// a dag is asked for, reading the file a chunk at a time.
+func digestFile(f File, o uploadOptions) (digest fileDigest, err error) {
+	reader, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer func() { _ = reader.Close() }()
+
+	hasher := hash.NewSha256()
+	contentHash := hasher.New()
+	if o.withDag {
+		digest.dagHash, digest.size, err = dag.HashFile(io.TeeReader(reader, contentHash), hasher)
+	} else {
+		digest.size, err = io.Copy(contentHash, reader)
+	}
+	if err != nil {
+		return
+	}
+
+	fileDigest := merkle.FileDigest{Path: f.Name, Size: digest.size, ContentHash: contentHash.Sum(nil)}
+	if o.leafFormat == merkle.LeafFormatMetadataAttrs {
+		fileDigest.Mode, fileDigest.ModTime = f.Mode, f.ModTime
+	}
+
+	digest.leafHash = o.leafFormat.LeafHash(fileDigest, hasher)
+
+	return
+}
+
+// orderFiles sorts the files, along with their digests, the same way the server places them as
+// tree leaves.
+func orderFiles(files []File, digests []fileDigest, ordering types.Ordering) ([]File, []fileDigest) {
+	if ordering == types.OrderingUpload {
+		return files, digests
+	}
+
+	names := make([]string, len(files))
+	leafHashes := make([][]byte, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+		leafHashes[i] = digests[i].leafHash
+	}
+
+	orderedFiles := make([]File, len(files))
+	orderedDigests := make([]fileDigest, len(files))
+	for i, position := range ordering.Permutation(names, leafHashes) {
+		orderedFiles[i] = files[position]
+		orderedDigests[i] = digests[position]
+	}
+
+	return orderedFiles, orderedDigests
+}
+
+// forEach calls fn with every index from 0 to n, up to concurrency calls at a time, and returns the
+// first error. No call is started once one failed or the context is done.
+func forEach(ctx context.Context, n, concurrency int, fn func(i int) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range max(min(concurrency, n), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range work {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := fn(i); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+// uploadMultipart sends the files in a single multipart request, streamed from the files as the
+// request is sent rather than built in memory beforehand.
+func (c *Client) uploadMultipart(
+	ctx context.Context,
+	files []File,
+	digests []fileDigest,
+	o uploadOptions,
+) (uploadResponse types.UploadedFilesResponse, err error) {
+	// every attempt writes the form anew, with the same boundary.
+	multipartWriter := multipart.NewWriter(io.Discard)
+
+	header := http.Header{}
+	header.Set("Content-Type", multipartWriter.FormDataContentType())
+	header.Set(types.HeaderIdempotencyKey, o.idempotencyKey)
+	response, err := c.do(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/upload", c.baseURL),
+		header,
+		func() (io.Reader, error) {
+			pipeReader, pipeWriter := io.Pipe()
+			go func() {
+				pipeWriter.CloseWithError(c.writeMultipartForm(pipeWriter, multipartWriter.Boundary(), files, digests, o))
+			}()
+
+			return pipeReader, nil
+		},
+	)
+	if err != nil {
+		err = fmt.Errorf("error sending POST request: %w", err)
+
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		return
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&uploadResponse); err != nil {
+		err = fmt.Errorf("error decoding json response: %s", err)
+	}
+
+	return
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// filePartHeader returns the header of the multipart file part, carrying the file attributes
+// if the leaf format commits to them.
+func filePartHeader(f File, leafFormat merkle.LeafFormat) textproto.MIMEHeader {
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set(
+		"Content-Disposition",
+		fmt.Sprintf(`form-data; name="files"; filename="%s"`, quoteEscaper.Replace(f.Name)),
+	)
+	partHeader.Set("Content-Type", "application/octet-stream")
+
+	if leafFormat == merkle.LeafFormatMetadataAttrs {
+		partHeader.Set(types.HeaderFileMode, strconv.FormatUint(uint64(f.Mode), 8))
+		partHeader.Set(types.HeaderFileModTime, strconv.FormatInt(f.ModTime, 10))
+	}
+
+	return partHeader
+}
+
+// writeMultipartForm writes the upload multipart form of the files to the writer, copying every
+// file content as it is read.
+func (c *Client) writeMultipartForm(
+	w io.Writer,
+	boundary string,
+	files []File,
+	digests []fileDigest,
+	o uploadOptions,
+) (err error) {
+	multipartWriter := multipart.NewWriter(w)
+	if err = multipartWriter.SetBoundary(boundary); err != nil {
+		return
+	}
+
+	if err = multipartWriter.WriteField(types.FormFieldOrdering, string(o.ordering)); err != nil {
+		return
+	}
+
+	if err = multipartWriter.WriteField(types.FormFieldLeafFormat, string(o.leafFormat)); err != nil {
+		return
+	}
+
+	if err = multipartWriter.WriteField(types.FormFieldDag, strconv.FormatBool(o.withDag)); err != nil {
+		return
+	}
+
+	for i, f := range files {
+		var filePart io.Writer
+		filePart, err = multipartWriter.CreatePart(filePartHeader(f, o.leafFormat))
+		if err != nil {
+			return
+		}
+
+		// copy the file content to the form file part
+		file := FileTransfer{Index: i + 1, Name: f.Name, Bytes: digests[i].size, Size: digests[i].size}
+		err = c.copyFile(filePart, f, file)
+		c.progress.FileFinished(file, err)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", f.Name, err)
+		}
+	}
+
+	// Close the multipart writer to finish building the request body
+	return multipartWriter.Close()
+}
+
+// copyFile copies the file content to the writer, reporting its progress. The errors opening and
+// reading the file are request body errors, unlike the errors writing to the request.
+func (c *Client) copyFile(w io.Writer, f File, file FileTransfer) error {
+	reader, err := f.Open()
+	if err != nil {
+		return &requestBodyError{err: err}
+	}
+	defer func() { _ = reader.Close() }()
+
+	_, err = io.Copy(w, &bodyReader{reader: newProgressReader(reader, file, c.progress)})
+
+	return err
+}
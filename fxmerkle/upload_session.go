@@ -0,0 +1,216 @@
+package fxmerkle
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// uploadSession sends the files one by one through an upload session, up to the upload
+// concurrency at a time, each at its position so the server orders them as they are ordered
+// here, then commits them as a batch. The session is aborted unless it is committed.
+func (c *Client) uploadSession(
+	ctx context.Context,
+	files []File,
+	digests []fileDigest,
+	o uploadOptions,
+) (uploadResponse types.UploadedFilesResponse, err error) {
+	id, replayed, err := c.openUploadSession(ctx, o)
+	if err != nil {
+		return
+	}
+
+	// the session of an upload sent again may already be committed.
+	if replayed {
+		if uploadResponse, err = c.commitUploadSession(ctx, id, len(files), o); err == nil {
+			return
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			_ = c.abortUploadSession(context.WithoutCancel(ctx), id)
+		}
+	}()
+
+	err = forEach(ctx, len(files), o.concurrency, func(i int) error {
+		file := FileTransfer{Index: i + 1, Name: files[i].Name, Bytes: digests[i].size, Size: digests[i].size}
+		err := c.sendSessionFile(ctx, id, file, files[i], digests[i], o.leafFormat)
+		c.progress.FileFinished(file, err)
+
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return c.commitUploadSession(ctx, id, len(files), o)
+}
+
+// openUploadSession opens an upload session, the session of an upload sent again with the same
+// idempotency key is opened again rather than a new one.
+func (c *Client) openUploadSession(ctx context.Context, o uploadOptions) (id string, replayed bool, err error) {
+	requestBody, err := json.Marshal(types.UploadSessionRequest{
+		Ordering:   o.ordering,
+		LeafFormat: o.leafFormat,
+		Dag:        o.withDag,
+	})
+	if err != nil {
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(types.HeaderIdempotencyKey, o.idempotencyKey)
+	response, err := c.do(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/uploads", c.baseURL),
+		header,
+		func() (io.Reader, error) { return bytes.NewReader(requestBody), nil },
+	)
+	if err != nil {
+		err = fmt.Errorf("error sending POST /uploads request: %w", err)
+
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusMethodNotAllowed {
+		err = errUploadSessionsUnsupported
+
+		return
+	}
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		return
+	}
+
+	var sessionResponse types.UploadSessionResponse
+	if err = json.NewDecoder(response.Body).Decode(&sessionResponse); err != nil {
+		err = fmt.Errorf("error decoding json response: %s", err)
+
+		return
+	}
+
+	replayed, _ = strconv.ParseBool(response.Header.Get(types.HeaderIdempotentReplayed))
+
+	return sessionResponse.ID, replayed, nil
+}
+
+// sendSessionFile sends the file at its position of the upload session, streamed from the file,
+// and makes sure the server received the leaf of the digest.
+func (c *Client) sendSessionFile(
+	ctx context.Context,
+	id string,
+	file FileTransfer,
+	f File,
+	digest fileDigest,
+	leafFormat merkle.LeafFormat,
+) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set(types.HeaderFileName, f.Name)
+	if leafFormat == merkle.LeafFormatMetadataAttrs {
+		header.Set(types.HeaderFileMode, strconv.FormatUint(uint64(f.Mode), 8))
+		header.Set(types.HeaderFileModTime, strconv.FormatInt(f.ModTime, 10))
+	}
+
+	response, err := c.do(
+		ctx,
+		http.MethodPut,
+		fmt.Sprintf("%s/uploads/%s/files/%d", c.baseURL, id, file.Index),
+		header,
+		func() (io.Reader, error) {
+			reader, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			return &bodyReader{reader: newProgressReader(reader, file, c.progress)}, nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error sending %s: %w", f.Name, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		return fmt.Errorf("error sending %s: %w", f.Name, err)
+	}
+
+	var uploadedFile types.UploadedFile
+	if err = json.NewDecoder(response.Body).Decode(&uploadedFile); err != nil {
+		return fmt.Errorf("error decoding json response: %s", err)
+	}
+
+	// a file changed since it was hashed, or altered on its way, is caught before the commit.
+	if uploadedFile.Hash != hex.EncodeToString(digest.leafHash) {
+		return fmt.Errorf(
+			"%w: local leaf hash %s of %s, server leaf hash %s",
+			errRootMismatch, hex.EncodeToString(digest.leafHash), f.Name, uploadedFile.Hash,
+		)
+	}
+
+	return nil
+}
+
+// commitUploadSession commits the files of the upload session as a new batch. Committing a session
+// again gets the response of the first commit, so the commit is sent with the idempotency key.
+func (c *Client) commitUploadSession(
+	ctx context.Context,
+	id string,
+	fileCount int,
+	o uploadOptions,
+) (uploadResponse types.UploadedFilesResponse, err error) {
+	requestBody, err := json.Marshal(types.UploadSessionCommitRequest{FileCount: fileCount})
+	if err != nil {
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(types.HeaderIdempotencyKey, o.idempotencyKey)
+	response, err := c.do(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/uploads/%s/commit", c.baseURL, id),
+		header,
+		func() (io.Reader, error) { return bytes.NewReader(requestBody), nil },
+	)
+	if err != nil {
+		err = fmt.Errorf("error sending POST /uploads/{id}/commit request: %w", err)
+
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if err = checkStatus(response, http.StatusOK); err != nil {
+		return
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&uploadResponse); err != nil {
+		err = fmt.Errorf("error decoding json response: %s", err)
+	}
+
+	return
+}
+
+// abortUploadSession aborts the upload session, the server releases the files sent so far.
+func (c *Client) abortUploadSession(ctx context.Context, id string) error {
+	response, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/uploads/%s", c.baseURL, id), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	return checkStatus(response, http.StatusNoContent)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	clientcli "github.com/TxCorpi0x/file-upload-merkle/client/cli"
 	servercli "github.com/TxCorpi0x/file-upload-merkle/server/cli"
@@ -26,7 +29,11 @@ func main() {
 	rootCmd.AddCommand(clientcli.Cmd)
 	rootCmd.AddCommand(servercli.Cmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	// an interrupt cancels the requests of the client commands and shuts the server down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
@@ -0,0 +1,253 @@
+package dag
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"testing/iotest"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+var testFiles = []File{
+	{Path: "a.txt", Content: []byte("a")},
+	{Path: "dir/b.txt", Content: []byte("bb")},
+	{Path: "dir/sub/c.txt", Content: []byte("ccc")},
+	{Path: "dir/sub/empty.txt", Content: []byte{}},
+}
+
+func newTestDag(t *testing.T, files []File) *Node {
+	t.Helper()
+
+	root, err := Build(files, hash.NewSha256())
+	if err != nil {
+		t.Fatalf("unable to build the dag: %s", err)
+	}
+
+	return root
+}
+
+func TestBuild(t *testing.T) {
+	hasher := hash.NewSha256()
+	root := newTestDag(t, testFiles)
+
+	if root.Kind != KindDir || root.Size != 6 {
+		t.Fatalf("root is a %s of %d bytes, expected a dir of 6 bytes", root.Kind, root.Size)
+	}
+
+	var names []string
+	for _, child := range root.Children {
+		names = append(names, child.Name)
+	}
+	if !slices.Equal(names, []string{"a.txt", "dir"}) {
+		t.Fatalf("root children %v", names)
+	}
+
+	sub, _, err := root.Lookup("dir/sub")
+	if err != nil || sub.Kind != KindDir || sub.Size != 3 || len(sub.Children) != 2 {
+		t.Fatalf("dir/sub is %+v: %v", sub, err)
+	}
+	if !bytes.Equal(sub.Hash, DirHash(sub.Entries(), hasher)) {
+		t.Fatal("dir/sub hash does not commit to its entries")
+	}
+
+	file, _, err := root.Lookup("dir/sub/c.txt")
+	if err != nil || file.Kind != KindFile || !bytes.Equal(file.Hash, FileHash([]byte("ccc"), hasher)) {
+		t.Fatalf("dir/sub/c.txt is %+v: %v", file, err)
+	}
+
+	// the dag doesn't depend on the order of the files.
+	reversed := slices.Clone(testFiles)
+	slices.Reverse(reversed)
+	if other := newTestDag(t, reversed); !bytes.Equal(other.Hash, root.Hash) {
+		t.Fatal("the dag of the reversed files has another root")
+	}
+
+	// the dag commits to the names and the contents.
+	for _, changed := range [][]File{
+		{testFiles[0], testFiles[1], testFiles[2], {Path: "dir/sub/renamed.txt", Content: []byte{}}},
+		{testFiles[0], testFiles[1], testFiles[2], {Path: "dir/sub/empty.txt", Content: []byte("x")}},
+		{testFiles[0], testFiles[1], testFiles[2], {Path: "dir/empty.txt", Content: []byte{}}},
+	} {
+		if other := newTestDag(t, changed); bytes.Equal(other.Hash, root.Hash) {
+			t.Errorf("the dag of %+v has the same root", changed)
+		}
+	}
+}
+
+func TestBuildRejectsConflictingPaths(t *testing.T) {
+	for name, files := range map[string][]File{
+		"file and dir": {{Path: "a"}, {Path: "a/b"}},
+		"dir and file": {{Path: "a/b"}, {Path: "a"}},
+		"duplicate":    {{Path: "a/b"}, {Path: "a/b"}},
+	} {
+		if _, err := Build(files, hash.NewSha256()); err == nil {
+			t.Errorf("%s: dag is built", name)
+		}
+	}
+}
+
+func TestEmptyDag(t *testing.T) {
+	hasher := hash.NewSha256()
+	root := newTestDag(t, nil)
+
+	if root.Kind != KindDir || root.Size != 0 || !bytes.Equal(root.Hash, DirHash(nil, hasher)) {
+		t.Fatalf("empty dag root is %+v", root)
+	}
+
+	node, proof, err := root.Lookup("")
+	if err != nil || node != root {
+		t.Fatalf("unable to look up the root: %v", err)
+	}
+
+	target, listing, err := proof.Verify(root.Hash, hasher)
+	if err != nil || target.Kind != KindDir || len(listing) != 0 {
+		t.Fatalf("empty directory proof verified to %+v, %v: %v", target, listing, err)
+	}
+
+	if _, _, err = root.Lookup("a"); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("looked up a path of the empty dag: %v", err)
+	}
+}
+
+func TestPathProofVerifies(t *testing.T) {
+	hasher := hash.NewSha256()
+	root := newTestDag(t, testFiles)
+
+	for _, nodePath := range []string{"", "a.txt", "dir", "dir/b.txt", "dir/sub", "/dir/sub/", "dir/sub/c.txt", "dir/sub/empty.txt"} {
+		node, proof, err := root.Lookup(nodePath)
+		if err != nil {
+			t.Fatalf("unable to look up %q: %s", nodePath, err)
+		}
+
+		target, listing, err := proof.Verify(root.Hash, hasher)
+		if err != nil {
+			t.Fatalf("proof of %q is not verified: %s", nodePath, err)
+		}
+		// the root is only proven by its hash, its size is the one of its listing.
+		if !bytes.Equal(target.Hash, node.Hash) || target.Kind != node.Kind || (node != root && target.Size != node.Size) {
+			t.Fatalf("proof of %q verified to %+v, expected %+v", nodePath, target, node.Entry)
+		}
+
+		if node.Kind == KindDir {
+			if len(listing) != len(node.Children) {
+				t.Fatalf("proof of %q lists %d entries, expected %d", nodePath, len(listing), len(node.Children))
+			}
+
+			continue
+		}
+
+		if listing != nil {
+			t.Fatalf("proof of file %q has a listing", nodePath)
+		}
+	}
+
+	entry, _, err := mustLookupProof(t, root, "dir/sub/c.txt").Verify(root.Hash, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyFile(entry, []byte("ccc"), hasher); err != nil {
+		t.Fatalf("content is not verified: %s", err)
+	}
+	if err = VerifyFile(entry, []byte("ccd"), hasher); !errors.Is(err, ErrInvalidPathProof) {
+		t.Fatalf("other content is verified: %v", err)
+	}
+}
+
+func TestPathProofRejectsTamperedProofs(t *testing.T) {
+	hasher := hash.NewSha256()
+	root := newTestDag(t, testFiles)
+
+	for name, test := range map[string]struct {
+		path   string
+		tamper func(proof *PathProof)
+	}{
+		"unlisted path": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Path = "dir/sub/d.txt"
+		}},
+		"path of another directory": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Path = "dir/other/c.txt"
+		}},
+		"path of a sibling directory": {path: "dir/b.txt", tamper: func(proof *PathProof) {
+			proof.Path = "a.txt/b.txt"
+		}},
+		"sibling hash": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Listings[1][0].Hash = hasher.Hash([]byte("tampered"))
+		}},
+		"target hash": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Listings[2][0].Hash = FileHash([]byte("tampered"), hasher)
+		}},
+		"target size": {path: "dir/sub", tamper: func(proof *PathProof) {
+			proof.Listings[2][1].Size++
+		}},
+		"renamed entry": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Listings[2][1].Name = "d.txt"
+		}},
+		"missing directory listing": {path: "dir/sub", tamper: func(proof *PathProof) {
+			proof.Listings = proof.Listings[:2]
+		}},
+		"file listing": {path: "dir/b.txt", tamper: func(proof *PathProof) {
+			proof.Listings = append(proof.Listings, nil)
+		}},
+		"missing parent listing": {path: "dir/sub/c.txt", tamper: func(proof *PathProof) {
+			proof.Listings = proof.Listings[1:]
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			proof := mustLookupProof(t, root, test.path)
+			test.tamper(&proof)
+
+			if _, _, err := proof.Verify(root.Hash, hasher); !errors.Is(err, ErrInvalidPathProof) {
+				t.Fatalf("tampered proof is verified: %v", err)
+			}
+		})
+	}
+
+	other := newTestDag(t, testFiles[:3])
+	if _, _, err := mustLookupProof(t, root, "a.txt").Verify(other.Hash, hasher); !errors.Is(err, ErrInvalidPathProof) {
+		t.Fatalf("proof is verified against another root: %v", err)
+	}
+}
+
+func TestLookupRejectsMissingPaths(t *testing.T) {
+	root := newTestDag(t, testFiles)
+	for _, nodePath := range []string{"b.txt", "dir/c.txt", "a.txt/b", "dir/sub/c.txt/d"} {
+		if _, _, err := root.Lookup(nodePath); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("looked up %q: %v", nodePath, err)
+		}
+	}
+}
+
+func TestHashFileMatchesFileHash(t *testing.T) {
+	hasher := hash.NewSha256()
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize} {
+		content := bytes.Repeat([]byte{'x'}, size)
+
+		// the content is read a byte at a time, so the chunks are filled by several writes.
+		fileHash, hashedSize, err := HashFile(iotest.OneByteReader(bytes.NewReader(content)), hasher)
+		if err != nil || hashedSize != int64(size) || !bytes.Equal(fileHash, FileHash(content, hasher)) {
+			t.Errorf("hashed %d bytes to %d bytes: %v", size, hashedSize, err)
+		}
+	}
+
+	if bytes.Equal(FileHash(nil, hasher), FileHash([]byte{0}, hasher)) {
+		t.Error("the empty file hash is the one of a zero byte")
+	}
+}
+
+// mustLookupProof returns the proof of the path, with its listings copied so they can be tampered.
+func mustLookupProof(t *testing.T, root *Node, nodePath string) PathProof {
+	t.Helper()
+
+	_, proof, err := root.Lookup(nodePath)
+	if err != nil {
+		t.Fatalf("unable to look up %q: %s", nodePath, err)
+	}
+
+	for i, listing := range proof.Listings {
+		proof.Listings[i] = slices.Clone(listing)
+	}
+
+	return proof
+}
@@ -0,0 +1,297 @@
+package dag
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// ChunkSize is the size of the chunks files are split into, the leaves of the file chunk trees.
+const ChunkSize = 256 << 10
+
+// prefixes separating the encoded file and directory nodes from any other hashed data.
+const (
+	filePrefix = "fxdag-file/v1"
+	dirPrefix  = "fxdag-dir/v1"
+	cidPrefix  = "fxdag1:"
+)
+
+var ErrPathNotFound = errors.New("path is not found in the dag")
+
+// Kind is the kind of node of the dag.
+type Kind string
+
+const (
+	KindFile Kind = "file"
+	KindDir  Kind = "dir"
+)
+
+// Entry is a named child of a directory node, the directory commits to the entries of its children.
+type Entry struct {
+	Name string    `json:"name"`
+	Kind Kind      `json:"kind"`
+	Hash hash.Hash `json:"hash"`
+	// Size is the file size, or the total size of the files under a directory.
+	Size int64 `json:"size"`
+}
+
+// Node is a file or a directory node of the dag.
+type Node struct {
+	Entry
+	// Children are the directory child nodes sorted by name, nil for files.
+	Children []*Node `json:"-"`
+}
+
+// File is a file to put in the dag under its slash separated relative path.
+type File struct {
+	Path    string
+	Content []byte
+	// Hash and Size stand for the content when the hash is set, such as for files hashed with
+	// HashFile while streamed.
+	Hash hash.Hash
+	Size int64
+}
+
+// Build builds the directory structured dag of the files, the returned node is the root directory.
+func Build(files []File, hasher hash.Hasher) (*Node, error) {
+	root := &Node{Entry: Entry{Kind: KindDir}}
+
+	for _, f := range files {
+		components := strings.Split(f.Path, "/")
+
+		dir := root
+		for _, name := range components[:len(components)-1] {
+			child := dir.child(name)
+			if child == nil {
+				child = &Node{Entry: Entry{Name: name, Kind: KindDir}}
+				dir.Children = append(dir.Children, child)
+			}
+
+			if child.Kind != KindDir {
+				return nil, fmt.Errorf("%s is both a file and a directory", f.Path)
+			}
+
+			dir = child
+		}
+
+		name := components[len(components)-1]
+		if dir.child(name) != nil {
+			return nil, fmt.Errorf("%s is added more than once", f.Path)
+		}
+
+		fileHash, size := f.Hash, f.Size
+		if fileHash == nil {
+			fileHash, size = FileHash(f.Content, hasher), int64(len(f.Content))
+		}
+
+		dir.Children = append(dir.Children, &Node{Entry: Entry{
+			Name: name,
+			Kind: KindFile,
+			Hash: fileHash,
+			Size: size,
+		}})
+	}
+
+	root.hashDir(hasher)
+
+	return root, nil
+}
+
+// FileHash returns the hash of the file node, which commits to the file size and to the root of
+// the merkle tree of its chunks.
+func FileHash(content []byte, hasher hash.Hasher) hash.Hash {
+	// an empty file is a single empty chunk.
+	chunkHashes := hash.HashList{hasher.Hash(content[:0])}
+	if len(content) > 0 {
+		chunkHashes = chunkHashes[:0]
+		for offset := 0; offset < len(content); offset += ChunkSize {
+			chunkHashes = append(chunkHashes, hasher.Hash(content[offset:min(offset+ChunkSize, len(content))]))
+		}
+	}
+
+	return fileHashFromChunks(int64(len(content)), chunkHashes, hasher)
+}
+
+// HashFile returns the hash and size of the file node of the content read from the reader, one
+// chunk at a time.
+func HashFile(r io.Reader, hasher hash.Hasher) (fileHash hash.Hash, size int64, err error) {
+	fileHasher := NewFileHasher(hasher)
+	if _, err = io.Copy(fileHasher, r); err != nil {
+		return nil, 0, err
+	}
+
+	fileHash, size = fileHasher.Sum()
+
+	return
+}
+
+// FileHasher computes the hash of the file node of the content written to it, one chunk at a time.
+type FileHasher struct {
+	hasher      hash.Hasher
+	chunk       []byte
+	chunkHashes hash.HashList
+	size        int64
+}
+
+func NewFileHasher(hasher hash.Hasher) *FileHasher {
+	return &FileHasher{hasher: hasher, chunk: make([]byte, 0, ChunkSize)}
+}
+
+func (h *FileHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// a full chunk is only hashed once more content follows, the last chunk may be a full one.
+		if len(h.chunk) == ChunkSize {
+			h.chunkHashes = append(h.chunkHashes, h.hasher.Hash(h.chunk))
+			h.chunk = h.chunk[:0]
+		}
+
+		n := copy(h.chunk[len(h.chunk):ChunkSize], p)
+		h.chunk, p = h.chunk[:len(h.chunk)+n], p[n:]
+		h.size += int64(n)
+	}
+
+	return written, nil
+}
+
+// Sum returns the hash and size of the file node of the content written so far.
+func (h *FileHasher) Sum() (hash.Hash, int64) {
+	// the last chunk is the only short one, an empty file is a single empty chunk.
+	chunkHashes := h.chunkHashes
+	if len(h.chunk) > 0 || len(chunkHashes) == 0 {
+		chunkHashes = append(chunkHashes[:len(chunkHashes):len(chunkHashes)], h.hasher.Hash(h.chunk))
+	}
+
+	return fileHashFromChunks(h.size, chunkHashes, h.hasher), h.size
+}
+
+// fileHashFromChunks returns the hash of the file node committing to the size of the file and the
+// merkle root of its chunks.
+func fileHashFromChunks(size int64, chunkHashes hash.HashList, hasher hash.Hasher) hash.Hash {
+	encoded := []byte(filePrefix)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(size))
+	encoded = binary.BigEndian.AppendUint64(encoded, ChunkSize)
+
+	return hasher.Hash(encoded, merkle.RootFromLeafHashes(chunkHashes, hasher))
+}
+
+// DirHash returns the hash of the directory node, which commits to the names, kinds, sizes and
+// hashes of the entries, sorted by name.
+func DirHash(entries []Entry, hasher hash.Hasher) hash.Hash {
+	sortedEntries := make([]Entry, len(entries))
+	copy(sortedEntries, entries)
+	sort.Slice(sortedEntries, func(i, j int) bool { return sortedEntries[i].Name < sortedEntries[j].Name })
+
+	encoded := []byte(dirPrefix)
+	for _, e := range sortedEntries {
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(e.Name)))
+		encoded = append(encoded, e.Name...)
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(e.Kind)))
+		encoded = append(encoded, e.Kind...)
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(e.Size))
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(e.Hash)))
+		encoded = append(encoded, e.Hash...)
+	}
+
+	return hasher.Hash(encoded)
+}
+
+// CID returns the content identifier of a node hash.
+func CID(nodeHash hash.Hash) string {
+	return cidPrefix + hex.EncodeToString(nodeHash)
+}
+
+// ParseCID parses a content identifier into the node hash.
+func ParseCID(cid string) (hash.Hash, error) {
+	hexHash, found := strings.CutPrefix(cid, cidPrefix)
+	if !found {
+		return nil, fmt.Errorf("content identifier %q must start with %s", cid, cidPrefix)
+	}
+
+	return hex.DecodeString(hexHash)
+}
+
+// CID returns the content identifier of the node.
+func (n *Node) CID() string {
+	return CID(n.Hash)
+}
+
+// Entries returns the entries of the directory node children.
+func (n *Node) Entries() []Entry {
+	entries := make([]Entry, len(n.Children))
+	for i, child := range n.Children {
+		entries[i] = child.Entry
+	}
+
+	return entries
+}
+
+// Lookup returns the node at the slash separated path, relative to the node, along with the proof
+// linking it to the node. An empty path is the node itself.
+func (n *Node) Lookup(nodePath string) (*Node, PathProof, error) {
+	proof := PathProof{Path: nodePath}
+
+	node := n
+	for _, name := range splitPath(nodePath) {
+		if node.Kind != KindDir {
+			return nil, PathProof{}, fmt.Errorf("%w: %s", ErrPathNotFound, nodePath)
+		}
+
+		proof.Listings = append(proof.Listings, node.Entries())
+
+		node = node.child(name)
+		if node == nil {
+			return nil, PathProof{}, fmt.Errorf("%w: %s", ErrPathNotFound, nodePath)
+		}
+	}
+
+	// the listing of a target directory is proven as well, so it can be verified without its children.
+	if node.Kind == KindDir {
+		proof.Listings = append(proof.Listings, node.Entries())
+	}
+
+	return node, proof, nil
+}
+
+// hashDir sorts the children of the directory by name and calculates the directory hash and size
+// out of the children, recursively.
+func (n *Node) hashDir(hasher hash.Hasher) {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+
+	n.Size = 0
+	for _, child := range n.Children {
+		if child.Kind == KindDir {
+			child.hashDir(hasher)
+		}
+
+		n.Size += child.Size
+	}
+
+	n.Hash = DirHash(n.Entries(), hasher)
+}
+
+func (n *Node) child(name string) *Node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+
+	return nil
+}
+
+func splitPath(nodePath string) []string {
+	nodePath = strings.Trim(nodePath, "/")
+	if nodePath == "" {
+		return nil
+	}
+
+	return strings.Split(nodePath, "/")
+}
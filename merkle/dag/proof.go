@@ -0,0 +1,87 @@
+package dag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+var ErrInvalidPathProof = errors.New("path proof does not match the dag root")
+
+// PathProof links a path to the root of the dag through the listings of the directories on the
+// way, so a file or a directory is proven without fetching its siblings.
+type PathProof struct {
+	Path string `json:"path"`
+	// Listings are the entries of every directory from the root down to the parent of a file,
+	// or down to the directory itself.
+	Listings [][]Entry `json:"listings"`
+}
+
+// Verify checks the proof against the root hash and returns the entry of the proven path, along
+// with its listing if it is a directory.
+func (p PathProof) Verify(rootHash hash.Hash, hasher hash.Hasher) (target Entry, listing []Entry, err error) {
+	names := splitPath(p.Path)
+	if len(p.Listings) < len(names) || len(p.Listings) > len(names)+1 {
+		err = fmt.Errorf("%w: %d listings for %d path components", ErrInvalidPathProof, len(p.Listings), len(names))
+
+		return
+	}
+
+	target = Entry{Kind: KindDir, Hash: rootHash}
+	for i, name := range names {
+		if target.Kind != KindDir || !bytes.Equal(DirHash(p.Listings[i], hasher), target.Hash) {
+			err = fmt.Errorf("%w: listing of %q", ErrInvalidPathProof, name)
+
+			return
+		}
+
+		var found bool
+		for _, e := range p.Listings[i] {
+			if e.Name == name {
+				target, found = e, true
+			}
+		}
+
+		if !found {
+			err = fmt.Errorf("%w: %q is not listed", ErrInvalidPathProof, name)
+
+			return
+		}
+	}
+
+	if target.Kind == KindFile {
+		if len(p.Listings) != len(names) {
+			err = fmt.Errorf("%w: file %q can't have a listing", ErrInvalidPathProof, p.Path)
+		}
+
+		return
+	}
+
+	if len(p.Listings) != len(names)+1 {
+		err = fmt.Errorf("%w: listing of directory %q is missing", ErrInvalidPathProof, p.Path)
+
+		return
+	}
+
+	listing = p.Listings[len(names)]
+	if !bytes.Equal(DirHash(listing, hasher), target.Hash) {
+		err = fmt.Errorf("%w: listing of directory %q", ErrInvalidPathProof, p.Path)
+	}
+
+	return
+}
+
+// VerifyFile checks the content of the file against the proven file entry.
+func VerifyFile(entry Entry, content []byte, hasher hash.Hasher) error {
+	if entry.Kind != KindFile {
+		return fmt.Errorf("%s is not a file", entry.Name)
+	}
+
+	if int64(len(content)) != entry.Size || !bytes.Equal(FileHash(content, hasher), entry.Hash) {
+		return fmt.Errorf("%w: content of %s", ErrInvalidPathProof, entry.Name)
+	}
+
+	return nil
+}
@@ -12,4 +12,6 @@ type Hasher interface {
 	Hash(data ...[]byte) Hash
 	// Len returns constant length of hashing algorithm.
 	Len() int
+	// Name returns the name of hashing algorithm.
+	Name() string
 }
@@ -1,10 +1,15 @@
 package hash
 
 import (
+	gohash "hash"
+
 	"golang.org/x/crypto/sha3"
 )
 
-const sha256Len = 32
+const (
+	sha256Len  = 32
+	sha256Name = "sha3-256"
+)
 
 // Sha256 is the 256-bit SHA3 hashing method.
 type Sha256 struct{}
@@ -36,7 +41,17 @@ func (algo *Sha256) Hash(data ...[]byte) Hash {
 	return hash[:]
 }
 
+// New returns a streaming SHA3 hash, its sum is the hash of the data written to it.
+func (*Sha256) New() gohash.Hash {
+	return sha3.New256()
+}
+
 // Len returns constant length of the hashing algorithm.
 func (*Sha256) Len() int {
 	return sha256Len
 }
+
+// Name returns the name of the hashing algorithm.
+func (*Sha256) Name() string {
+	return sha256Name
+}
@@ -0,0 +1,117 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// LeafFormat is the format of the data committed to by the leaves of a files tree.
+type LeafFormat string
+
+const (
+	// LeafFormatContent leaves commit to the file content only.
+	LeafFormatContent LeafFormat = "content"
+	// LeafFormatMetadata leaves commit to the file path, size and content hash.
+	LeafFormatMetadata LeafFormat = "metadata"
+	// LeafFormatMetadataAttrs leaves commit to the file mode and modification time as well.
+	LeafFormatMetadataAttrs LeafFormat = "metadata+attrs"
+)
+
+// leafPrefix separates the encoded file leaves from any other hashed data.
+const leafPrefix = "fxmerkle-leaf/v1"
+
+// ParseLeafFormat parses the leaf format name, an empty name is the content format.
+func ParseLeafFormat(name string) (LeafFormat, error) {
+	switch LeafFormat(name) {
+	case "", LeafFormatContent:
+		return LeafFormatContent, nil
+	case LeafFormatMetadata, LeafFormatMetadataAttrs:
+		return LeafFormat(name), nil
+	default:
+		return "", fmt.Errorf(
+			"unknown leaf format %q, expected %s, %s or %s",
+			name, LeafFormatContent, LeafFormatMetadata, LeafFormatMetadataAttrs,
+		)
+	}
+}
+
+// FileLeaf is a file as committed to by a tree leaf.
+type FileLeaf struct {
+	// Path is the normalized, slash separated relative path of the file.
+	Path    string
+	Content []byte
+	// Mode is the permission bits of the file, only committed in the metadata+attrs format.
+	Mode uint32
+	// ModTime is the unix modification time of the file, only committed in the metadata+attrs format.
+	ModTime int64
+}
+
+// Data returns the input data of the tree leaf for the file in the leaf format.
+func (f LeafFormat) Data(leaf FileLeaf, hasher hash.Hasher) []byte {
+	switch f {
+	case LeafFormatMetadata:
+		leaf.Mode, leaf.ModTime = 0, 0
+
+		return leaf.encode(hasher)
+	case LeafFormatMetadataAttrs:
+		return leaf.encode(hasher)
+	default:
+		return leaf.Content
+	}
+}
+
+// FileDigest is a file as committed to by a tree leaf with its content reduced to its size and
+// hash, so the leaf hash of a file can be computed while streaming it.
+type FileDigest struct {
+	Path string
+	Size int64
+	// ContentHash is the hash of the content by the hasher of the tree.
+	ContentHash hash.Hash
+	Mode        uint32
+	ModTime     int64
+}
+
+// LeafHash returns the hash of the tree leaf of the file in the leaf format, the same as the hash
+// of the leaf data of the file.
+func (f LeafFormat) LeafHash(digest FileDigest, hasher hash.Hasher) hash.Hash {
+	switch f {
+	case LeafFormatMetadata:
+		digest.Mode, digest.ModTime = 0, 0
+
+		return hasher.Hash(digest.encode())
+	case LeafFormatMetadataAttrs:
+		return hasher.Hash(digest.encode())
+	default:
+		// the leaf is the content itself.
+		return digest.ContentHash
+	}
+}
+
+func (l FileLeaf) encode(hasher hash.Hasher) []byte {
+	return FileDigest{
+		Path:        l.Path,
+		Size:        int64(len(l.Content)),
+		ContentHash: hasher.Hash(l.Content),
+		Mode:        l.Mode,
+		ModTime:     l.ModTime,
+	}.encode()
+}
+
+// encode returns the canonical encoding of the file metadata:
+// prefix | len(path) | path | size | len(content hash) | content hash | mode | modification time
+// with lengths and numbers as big endian 64-bit integers.
+func (d FileDigest) encode() []byte {
+	encoded := make([]byte, 0, len(leafPrefix)+len(d.Path)+len(d.ContentHash)+5*8)
+	encoded = append(encoded, leafPrefix...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(d.Path)))
+	encoded = append(encoded, d.Path...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(d.Size))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(d.ContentHash)))
+	encoded = append(encoded, d.ContentHash...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(d.Mode))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(d.ModTime))
+
+	return encoded
+}
@@ -0,0 +1,133 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+var testFileLeaf = FileLeaf{Path: "dir/a.txt", Content: []byte("content"), Mode: 0o644, ModTime: 1700000000}
+
+// testLeafEncoding returns the encoding of the file leaf as documented, written out by hand.
+func testLeafEncoding(leaf FileLeaf, hasher hash.Hasher) []byte {
+	contentHash := hasher.Hash(leaf.Content)
+
+	var encoded []byte
+	encoded = append(encoded, "fxmerkle-leaf/v1"...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(leaf.Path)))
+	encoded = append(encoded, leaf.Path...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(leaf.Content)))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(contentHash)))
+	encoded = append(encoded, contentHash...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(leaf.Mode))
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(leaf.ModTime))
+
+	return encoded
+}
+
+func TestLeafFormatData(t *testing.T) {
+	hasher := hash.NewSha256()
+	withoutAttrs := testFileLeaf
+	withoutAttrs.Mode, withoutAttrs.ModTime = 0, 0
+
+	encodings := map[LeafFormat][]byte{
+		LeafFormatContent:       testFileLeaf.Content,
+		LeafFormatMetadata:      testLeafEncoding(withoutAttrs, hasher),
+		LeafFormatMetadataAttrs: testLeafEncoding(testFileLeaf, hasher),
+	}
+	for format, expected := range encodings {
+		data := format.Data(testFileLeaf, hasher)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%s leaf data is %x, expected %x", format, data, expected)
+		}
+
+		// the data is the same every time, and its hash is the leaf hash of the file digest.
+		if again := format.Data(testFileLeaf, hasher); !bytes.Equal(again, data) {
+			t.Errorf("%s leaf data changed from %x to %x", format, data, again)
+		}
+
+		leafHash := format.LeafHash(FileDigest{
+			Path:        testFileLeaf.Path,
+			Size:        int64(len(testFileLeaf.Content)),
+			ContentHash: hasher.Hash(testFileLeaf.Content),
+			Mode:        testFileLeaf.Mode,
+			ModTime:     testFileLeaf.ModTime,
+		}, hasher)
+		if !bytes.Equal(leafHash, hasher.Hash(data)) {
+			t.Errorf("%s leaf hash is not the hash of the leaf data", format)
+		}
+	}
+
+	for _, format := range []LeafFormat{LeafFormatContent, LeafFormatMetadata} {
+		if bytes.Equal(encodings[format], encodings[LeafFormatMetadataAttrs]) {
+			t.Errorf("%s leaf data is the one of the %s format", format, LeafFormatMetadataAttrs)
+		}
+	}
+}
+
+func TestLeafFormatCommitsToTheFile(t *testing.T) {
+	hasher := hash.NewSha256()
+	for name, test := range map[string]struct {
+		change func(leaf *FileLeaf)
+		// changes are the formats whose leaf data changes.
+		changes []LeafFormat
+	}{
+		"content": {
+			change:  func(leaf *FileLeaf) { leaf.Content = []byte("changed") },
+			changes: []LeafFormat{LeafFormatContent, LeafFormatMetadata, LeafFormatMetadataAttrs},
+		},
+		"name": {
+			change:  func(leaf *FileLeaf) { leaf.Path = "dir/b.txt" },
+			changes: []LeafFormat{LeafFormatMetadata, LeafFormatMetadataAttrs},
+		},
+		"directory": {
+			change:  func(leaf *FileLeaf) { leaf.Path = "a.txt" },
+			changes: []LeafFormat{LeafFormatMetadata, LeafFormatMetadataAttrs},
+		},
+		"mode": {
+			change:  func(leaf *FileLeaf) { leaf.Mode = 0o755 },
+			changes: []LeafFormat{LeafFormatMetadataAttrs},
+		},
+		"modification time": {
+			change:  func(leaf *FileLeaf) { leaf.ModTime++ },
+			changes: []LeafFormat{LeafFormatMetadataAttrs},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			changed := testFileLeaf
+			test.change(&changed)
+
+			for _, format := range []LeafFormat{LeafFormatContent, LeafFormatMetadata, LeafFormatMetadataAttrs} {
+				expectChange := false
+				for _, changing := range test.changes {
+					expectChange = expectChange || changing == format
+				}
+
+				if bytes.Equal(format.Data(changed, hasher), format.Data(testFileLeaf, hasher)) == expectChange {
+					t.Errorf("%s leaf data changed: %v, expected %v", format, !expectChange, expectChange)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLeafFormat(t *testing.T) {
+	for name, expected := range map[string]LeafFormat{
+		"":               LeafFormatContent,
+		"content":        LeafFormatContent,
+		"metadata":       LeafFormatMetadata,
+		"metadata+attrs": LeafFormatMetadataAttrs,
+	} {
+		if format, err := ParseLeafFormat(name); err != nil || format != expected {
+			t.Errorf("parsed %q to %q, expected %q: %v", name, format, expected, err)
+		}
+	}
+
+	for _, name := range []string{"Content", "metadata+", "attrs", " metadata"} {
+		if format, err := ParseLeafFormat(name); err == nil {
+			t.Errorf("parsed the unknown leaf format %q to %q", name, format)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// MultiProof is a proof of several leaves of a Merkle tree at once, it carries the hashes of the
+// nodes which can't be computed from the proven leaves, each of them once.
+type MultiProof struct {
+	// Indexes are the sorted, distinct indexes of the proven leaves.
+	Indexes []uint64      `json:"indexes"`
+	Hashes  hash.HashList `json:"hashes"`
+	// Size is the number of leaves of the tree, it tells the depth of the tree.
+	Size uint64 `json:"size"`
+}
+
+// MultiProof returns the proof of the leaves at the indexes, which are sorted and deduplicated.
+func (t *Tree) MultiProof(indexes []uint64) (*MultiProof, error) {
+	indexes = slices.Clone(indexes)
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	if len(indexes) == 0 {
+		return nil, errors.New("no index to prove")
+	}
+	if indexes[len(indexes)-1] >= uint64(t.Size) {
+		return nil, errors.New("index out of range")
+	}
+
+	// walk up the tree level by level, the nodes known at a level are the ones of the proven
+	// leaves or computed from the level below, their siblings are either known or part of the proof.
+	var hashes hash.HashList
+	known := nodePositions(indexes, uint64(t.BranchesLen()))
+	for len(known) > 1 || known[0] > 1 {
+		var parents []uint64
+		for i := 0; i < len(known); i++ {
+			if i+1 < len(known) && known[i+1] == known[i]^1 {
+				i++
+			} else {
+				hashes = append(hashes, t.Nodes[known[i]^1])
+			}
+
+			parents = append(parents, known[i]/2)
+		}
+
+		known = parents
+	}
+
+	return &MultiProof{Indexes: indexes, Hashes: hashes, Size: uint64(t.Size)}, nil
+}
+
+// Root computes the root hash from the hashes of the proven leaves, in the order of the indexes.
+func (p *MultiProof) Root(leafHashes hash.HashList, hasher hash.Hasher) ([]byte, error) {
+	if len(p.Indexes) == 0 || len(leafHashes) != len(p.Indexes) {
+		return nil, fmt.Errorf("multi-proof of %d leaves is given %d leaf hashes", len(p.Indexes), len(leafHashes))
+	}
+
+	for i, index := range p.Indexes {
+		if index >= p.Size || (i > 0 && index <= p.Indexes[i-1]) {
+			return nil, errors.New("multi-proof indexes must be sorted, distinct and within the tree")
+		}
+	}
+
+	levels := math.Ceil(math.Log2(float64(p.Size)))
+	known := nodePositions(p.Indexes, uint64(math.Exp2(levels)))
+	knownHashes := slices.Clone(leafHashes)
+	proofHashes := p.Hashes
+
+	for len(known) > 1 || known[0] > 1 {
+		var parents []uint64
+		var parentHashes hash.HashList
+		for i := 0; i < len(known); i++ {
+			position := known[i]
+			left, right := knownHashes[i], []byte(nil)
+			if i+1 < len(known) && known[i+1] == position^1 {
+				// both children are known, the first one is on the left.
+				right = knownHashes[i+1]
+				i++
+			} else {
+				if len(proofHashes) == 0 {
+					return nil, errors.New("multi-proof is missing hashes")
+				}
+
+				right, proofHashes = proofHashes[0], proofHashes[1:]
+				if position%2 == 1 {
+					// the known node is on the right hand side of its branch.
+					left, right = right, left
+				}
+			}
+
+			parentHashes = append(parentHashes, hasher.Hash(left, right))
+			parents = append(parents, position/2)
+		}
+
+		known, knownHashes = parents, parentHashes
+	}
+
+	if len(proofHashes) != 0 {
+		return nil, errors.New("multi-proof carries extra hashes")
+	}
+
+	return knownHashes[0], nil
+}
+
+// Verify if the root hash is the one computed from the hashes of the proven leaves.
+func (p *MultiProof) Verify(leafHashes hash.HashList, rootHash hash.Hash, hasher hash.Hasher) (bool, error) {
+	proofRoot, err := p.Root(leafHashes, hasher)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(rootHash, proofRoot), nil
+}
+
+// nodePositions returns the positions of the leaves at the sorted indexes among the tree nodes,
+// the leaves start at the offset.
+func nodePositions(indexes []uint64, offset uint64) []uint64 {
+	positions := make([]uint64, len(indexes))
+	for i, index := range indexes {
+		positions[i] = offset + index
+	}
+
+	return positions
+}
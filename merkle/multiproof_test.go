@@ -0,0 +1,212 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// newTestTree returns the tree of the leaves "leaf 0" to "leaf size-1".
+func newTestTree(t *testing.T, size int) *Tree {
+	t.Helper()
+
+	data := make(Input, size)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+
+	tree, err := NewTree(data, hash.NewSha256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+// testLeafHashes returns the hashes of the leaves of the tree at the indexes.
+func testLeafHashes(t *testing.T, tree *Tree, indexes []uint64) hash.HashList {
+	t.Helper()
+
+	leafHashes := make(hash.HashList, len(indexes))
+	for i, index := range indexes {
+		leafHash, err := tree.LeafHash(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leafHashes[i] = leafHash
+	}
+
+	return leafHashes
+}
+
+func TestMultiProofVerifies(t *testing.T) {
+	allIndexes := func(size int) []uint64 {
+		indexes := make([]uint64, size)
+		for i := range indexes {
+			indexes[i] = uint64(i)
+		}
+
+		return indexes
+	}
+
+	for _, size := range []int{1, 2, 3, 5, 6, 7, 8, 9, 16, 17} {
+		last := uint64(size - 1)
+		indexSets := map[string][]uint64{
+			"first":  {0},
+			"last":   {last},
+			"all":    allIndexes(size),
+			"repeat": {last, 0, last},
+		}
+		if size > 2 {
+			indexSets["adjacent"] = []uint64{1, 2}
+			indexSets["disjoint"] = []uint64{0, last}
+		}
+		if size > 8 {
+			indexSets["disjoint subtrees"] = []uint64{1, 4, 5, 8}
+		}
+
+		for name, indexes := range indexSets {
+			t.Run(fmt.Sprintf("%d leaves/%s", size, name), func(t *testing.T) {
+				tree := newTestTree(t, size)
+				proof, err := tree.MultiProof(indexes)
+				if err != nil {
+					t.Fatalf("unable to create the multi-proof: %s", err)
+				}
+
+				expectedIndexes := slices.Clone(indexes)
+				slices.Sort(expectedIndexes)
+				expectedIndexes = slices.Compact(expectedIndexes)
+				if !slices.Equal(proof.Indexes, expectedIndexes) {
+					t.Fatalf("multi-proof of indexes %v, expected %v", proof.Indexes, expectedIndexes)
+				}
+
+				verified, err := proof.Verify(testLeafHashes(t, tree, proof.Indexes), tree.Root(), hash.NewSha256())
+				if err != nil || !verified {
+					t.Fatalf("multi-proof is not verified: %v", err)
+				}
+
+				// the hashes of the nodes known from the leaves aren't carried.
+				if len(proof.Indexes) == size && len(proof.Hashes) > int(tree.LevelsLen()) {
+					t.Fatalf("multi-proof of every leaf carries %d hashes", len(proof.Hashes))
+				}
+			})
+		}
+	}
+}
+
+func TestMultiProofRejectsTamperedProofs(t *testing.T) {
+	hasher := hash.NewSha256()
+	tree := newTestTree(t, 7)
+	proof, err := tree.MultiProof([]uint64{1, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafHashes := testLeafHashes(t, tree, proof.Indexes)
+	for name, test := range map[string]struct {
+		tamper   func(proof *MultiProof, leafHashes hash.HashList) hash.HashList
+		rejected bool
+	}{
+		"tampered hash": {tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Hashes[0] = hasher.Hash([]byte("tampered"))
+
+			return leafHashes
+		}},
+		"tampered leaf": {tamper: func(_ *MultiProof, leafHashes hash.HashList) hash.HashList {
+			return hash.HashList{leafHashes[0], hasher.Hash([]byte("tampered"))}
+		}},
+		"swapped leaves": {tamper: func(_ *MultiProof, leafHashes hash.HashList) hash.HashList {
+			return hash.HashList{leafHashes[1], leafHashes[0]}
+		}},
+		"other index": {tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Indexes = []uint64{1, 5}
+
+			return leafHashes
+		}},
+		"missing hash": {rejected: true, tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Hashes = proof.Hashes[:len(proof.Hashes)-1]
+
+			return leafHashes
+		}},
+		"extra hash": {rejected: true, tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Hashes = append(proof.Hashes, hasher.Hash([]byte("extra")))
+
+			return leafHashes
+		}},
+		"missing leaf": {rejected: true, tamper: func(_ *MultiProof, leafHashes hash.HashList) hash.HashList {
+			return leafHashes[:1]
+		}},
+		"unsorted indexes": {rejected: true, tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Indexes = []uint64{4, 1}
+
+			return leafHashes
+		}},
+		"index out of the tree": {rejected: true, tamper: func(proof *MultiProof, leafHashes hash.HashList) hash.HashList {
+			proof.Indexes = []uint64{1, 7}
+
+			return leafHashes
+		}},
+		"no index": {rejected: true, tamper: func(proof *MultiProof, _ hash.HashList) hash.HashList {
+			proof.Indexes = nil
+
+			return nil
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tampered := &MultiProof{
+				Indexes: slices.Clone(proof.Indexes),
+				Hashes:  slices.Clone(proof.Hashes),
+				Size:    proof.Size,
+			}
+
+			verified, err := tampered.Verify(test.tamper(tampered, slices.Clone(leafHashes)), tree.Root(), hasher)
+			if verified {
+				t.Fatal("tampered multi-proof is verified")
+			}
+			if test.rejected != (err != nil) {
+				t.Fatalf("tampered multi-proof is rejected with %v", err)
+			}
+		})
+	}
+}
+
+func TestMultiProofRejectsInvalidIndexes(t *testing.T) {
+	tree := newTestTree(t, 5)
+	for _, indexes := range [][]uint64{nil, {5}, {0, 9}} {
+		if _, err := tree.MultiProof(indexes); err == nil {
+			t.Errorf("multi-proof of indexes %v is created", indexes)
+		}
+	}
+}
+
+func TestProofVerifies(t *testing.T) {
+	hasher := hash.NewSha256()
+	for _, size := range []int{1, 2, 3, 5, 8, 9} {
+		tree := newTestTree(t, size)
+		for i, data := range tree.Input {
+			proof, err := tree.Proof(data)
+			if err != nil || proof.Index != uint64(i) {
+				t.Fatalf("proof of leaf %d of %d leaves: %+v, %v", i, size, proof, err)
+			}
+
+			if verified, _ := proof.Verify(data, tree.Root(), hasher); !verified {
+				t.Errorf("proof of leaf %d of %d leaves is not verified", i, size)
+			}
+			if verified, _ := proof.Verify([]byte("other"), tree.Root(), hasher); verified {
+				t.Errorf("proof of leaf %d of %d leaves verifies other data", i, size)
+			}
+
+			if size > 1 {
+				tampered := &Proof{Index: proof.Index, Hashes: slices.Clone(proof.Hashes)}
+				tampered.Hashes[0] = bytes.Repeat([]byte{1}, hasher.Len())
+				if verified, _ := tampered.Verify(data, tree.Root(), hasher); verified {
+					t.Errorf("tampered proof of leaf %d of %d leaves is verified", i, size)
+				}
+			}
+		}
+	}
+}
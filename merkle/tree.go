@@ -16,28 +16,50 @@ type Input [][]byte
 type Tree struct {
 	// is the implemented Hasher interface for the desired hashing algorithm (e.g. Sha256).
 	hasher hash.Hasher
-	// Input is the source data to create the merkle tree.
-	Input Input `json:"input"`
+	// Input is the source data the merkle tree is created from, only held by the trees created by
+	// NewTree, it is not stored along with the tree.
+	Input Input `json:"-"`
+	// Size is the number of leaves.
+	Size int `json:"size"`
 	// Nodes carries leaves and branches.
 	Nodes hash.HashList `json:"nodes"`
 }
 
 // NewTree creates a new merkle tree using the provided information.
 func NewTree(data Input, hasher hash.Hasher) (*Tree, error) {
-	tree := &Tree{hasher: hasher, Input: data}
+	leafHashes := make(hash.HashList, len(data))
+	for i := range data {
+		leafHashes[i] = hasher.Hash(data[i])
+	}
+
+	tree, err := NewTreeFromLeafHashes(leafHashes, hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Input = data
+
+	return tree, nil
+}
+
+// NewTreeFromLeafHashes creates the merkle tree of the leaves of the hashes, the same as the one
+// of NewTree, without holding the leaves.
+func NewTreeFromLeafHashes(leafHashes hash.HashList, hasher hash.Hasher) (*Tree, error) {
+	if len(leafHashes) == 0 {
+		return nil, errors.New("a merkle tree needs at least one leaf")
+	}
+
+	tree := &Tree{hasher: hasher, Size: len(leafHashes)}
 
 	// calculate branches length of tree according to the input data
 	branchesLen := tree.BranchesLen()
 
 	// if we have x branches this means that we have double nodes.
 	nodes := make(hash.HashList, branchesLen*2)
-
-	// fill hashes extract bottom line leaves
-	addedNodes := nodes[branchesLen : branchesLen+len(tree.Input)]
-	tree.fillHashes(addedNodes)
+	copy(nodes[branchesLen:], leafHashes)
 
 	// allocate nodes hashes for leaves.
-	for i := len(tree.Input) + branchesLen; i < len(nodes); i++ {
+	for i := len(leafHashes) + branchesLen; i < len(nodes); i++ {
 		nodes[i] = make([]byte, hasher.Len())
 	}
 
@@ -49,12 +71,22 @@ func NewTree(data Input, hasher hash.Hasher) (*Tree, error) {
 	return tree, nil
 }
 
+// RootFromLeafHashes returns the root hash of the tree of the leaves of the hashes.
+func RootFromLeafHashes(leafHashes hash.HashList, hasher hash.Hasher) hash.Hash {
+	tree, err := NewTreeFromLeafHashes(leafHashes, hasher)
+	if err != nil {
+		return nil
+	}
+
+	return tree.Root()
+}
+
 // LevelsLen calculates the levels length of the tree according to the data length.
 // number of levels of a merkle tree follow Log2(n) since the number of nodes doubles every level
 // e.g 1M leaves Log2(1M) = 20
 // e.g 2M leaves Log2(2M) = 30
 func (t *Tree) LevelsLen() float64 {
-	return math.Ceil(math.Log2(float64(len(t.Input))))
+	return math.Ceil(math.Log2(float64(t.Size)))
 }
 
 // BranchesLen calculates the total number of branches in the tree.
@@ -66,7 +98,8 @@ func (t *Tree) BranchesLen() int {
 	return int(math.Exp2(t.LevelsLen()))
 }
 
-// Proof generates proof for the node with the input content.
+// Proof generates proof for the node with the input content, the tree must be created rather
+// than decoded as it hashes the content.
 func (t *Tree) Proof(data []byte) (*Proof, error) {
 	// Find the idx of the data
 	idx, err := t.indexOf(data)
@@ -74,12 +107,12 @@ func (t *Tree) Proof(data []byte) (*Proof, error) {
 		return nil, err
 	}
 
-	return t.proofByIndex(idx)
+	return t.ProofByIndex(idx)
 }
 
-// returns proof of node by input index.
-func (t *Tree) proofByIndex(idx uint64) (*Proof, error) {
-	if uint64(len(t.Input)) <= idx {
+// ProofByIndex returns proof of node by input index.
+func (t *Tree) ProofByIndex(idx uint64) (*Proof, error) {
+	if uint64(t.Size) <= idx {
 		return nil, errors.New("index out of range")
 	}
 
@@ -103,22 +136,25 @@ func (t *Tree) proofByIndex(idx uint64) (*Proof, error) {
 	return newProof(hashes, idx), nil
 }
 
-// finds the index of the data to be proven in the merkle tree.
-func (t *Tree) indexOf(input []byte) (uint64, error) {
-	for i, data := range t.Input {
-		if bytes.Equal(data, input) {
-			return uint64(i), nil
-		}
+// LeafHash returns the hash of the leaf at the index.
+func (t *Tree) LeafHash(idx uint64) (hash.Hash, error) {
+	if uint64(t.Size) <= idx {
+		return nil, errors.New("index out of range")
 	}
 
-	return 0, errors.New("input content was not found in the merkle")
+	return t.Nodes[uint64(len(t.Nodes)/2)+idx], nil
 }
 
-// fills the hashes list of the tree pointer.
-func (t *Tree) fillHashes(hashes hash.HashList) {
-	for i := range t.Input {
-		hashes[i] = t.hasher.Hash(t.Input[i])
+// finds the index of the data to be proven in the merkle tree, by the hash of the leaves.
+func (t *Tree) indexOf(input []byte) (uint64, error) {
+	inputHash := t.hasher.Hash(input)
+	for i := uint64(0); i < uint64(t.Size); i++ {
+		if leafHash, _ := t.LeafHash(i); bytes.Equal(leafHash, inputHash) {
+			return i, nil
+		}
 	}
+
+	return 0, errors.New("input content was not found in the merkle")
 }
 
 // fills branches with the corresponding hashes.
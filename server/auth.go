@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// Scope is a set of endpoints an api key may call.
+type Scope string
+
+const (
+	// ScopeUpload allows uploading batches.
+	ScopeUpload Scope = "upload"
+	// ScopeDownload allows reading the files, proofs, listings and archives of the batches.
+	ScopeDownload Scope = "download"
+	// ScopeAdmin allows deleting batches, along with everything the other scopes allow.
+	ScopeAdmin Scope = "admin"
+)
+
+var errBatchForbidden = errors.New("api key is not allowed to access batch")
+
+// APIKey is a key allowed to call the endpoints of its scopes. If batches are listed the key only
+// reads or deletes those batches.
+type APIKey struct {
+	Key     string  `json:"key"`
+	Scopes  []Scope `json:"scopes"`
+	Batches []int   `json:"batches,omitempty"`
+}
+
+// hasScope tells whether the key may call the endpoints of the scope, the admin scope allows all.
+func (k *APIKey) hasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// allowsBatch tells whether the key may read or delete the batch.
+func (k *APIKey) allowsBatch(batchID int) bool {
+	return len(k.Batches) == 0 || slices.Contains(k.Batches, batchID)
+}
+
+// LoadAPIKeysFile reads the json array of api keys from the file.
+func LoadAPIKeysFile(filename string) (keys []APIKey, err error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read api keys file: %s", err)
+	}
+
+	if err = json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("unable to decode api keys file: %s", err)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("api keys file holds no key")
+	}
+
+	return keys, nil
+}
+
+// ParseAPIKeys parses the comma separated api keys written as key:scopes[:batches], where the
+// scopes and the batch ids are separated by "+", e.g. "k1:upload+download,k2:download:3+4".
+func ParseAPIKeys(value string) (keys []APIKey, err error) {
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid api key entry %q, expected key:scopes[:batches]", entry)
+		}
+
+		key := APIKey{Key: fields[0]}
+		for _, scope := range strings.Split(fields[1], "+") {
+			key.Scopes = append(key.Scopes, Scope(scope))
+		}
+
+		if len(fields) == 3 {
+			for _, batchParam := range strings.Split(fields[2], "+") {
+				batchID, err := strconv.Atoi(batchParam)
+				if err != nil {
+					return nil, fmt.Errorf("invalid batch %q of api key entry %q", batchParam, entry)
+				}
+
+				key.Batches = append(key.Batches, batchID)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Authenticator authenticates the requests by their api key and checks the key scope allows the
+// endpoint. Reads need the download scope, posts the upload scope and deletions the admin scope.
+// The tree head and the public key are public, as they are what the clients verify against.
+type Authenticator struct {
+	// keys are indexed by the key hash, the lookup doesn't leak the key through its timing.
+	keys map[[sha256.Size]byte]*APIKey
+}
+
+func NewAuthenticator(keys []APIKey) (*Authenticator, error) {
+	a := &Authenticator{keys: make(map[[sha256.Size]byte]*APIKey, len(keys))}
+	for i := range keys {
+		key := &keys[i]
+		if key.Key == "" {
+			return nil, errors.New("api key can not be empty")
+		}
+
+		for _, scope := range key.Scopes {
+			if scope != ScopeUpload && scope != ScopeDownload && scope != ScopeAdmin {
+				return nil, fmt.Errorf("unknown api key scope %q, expected upload, download or admin", scope)
+			}
+		}
+
+		keyHash := sha256.Sum256([]byte(key.Key))
+		if _, found := a.keys[keyHash]; found {
+			return nil, errors.New("api keys must be unique")
+		}
+
+		a.keys[keyHash] = key
+	}
+
+	return a, nil
+}
+
+// publicRoutes are the routes served without api key.
+var publicRoutes = []string{"/sth", "/pubkey"}
+
+// Middleware rejects the requests without a known api key with 401 and those whose key scope
+// doesn't allow the endpoint with 403. The key is passed along to the handlers, which check the
+// batches they serve are allowed.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if pathTemplate, err := route.GetPathTemplate(); err == nil && slices.Contains(publicRoutes, pathTemplate) {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+		}
+
+		key, found := a.keys[sha256.Sum256([]byte(r.Header.Get(types.HeaderAPIKey)))]
+		if !found {
+			w.Header().Set("WWW-Authenticate", types.HeaderAPIKey)
+			httpError(w, http.StatusUnauthorized, fmt.Errorf("missing or unknown api key for %s %s", r.Method, r.URL.Path))
+
+			return
+		}
+
+		if scope := requiredScope(r); !key.hasScope(scope) {
+			httpError(w, http.StatusForbidden, fmt.Errorf("api key lacks the %s scope for %s %s", scope, r.Method, r.URL.Path))
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// requiredScope is the scope of the endpoint, uploads are the only posts and puts and aborting an
+// upload session is the only deletion of a non admin.
+func requiredScope(r *http.Request) Scope {
+	switch {
+	case r.Method == http.MethodPost, r.Method == http.MethodPut:
+		return ScopeUpload
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/uploads/"):
+		return ScopeUpload
+	case r.Method == http.MethodDelete:
+		return ScopeAdmin
+	default:
+		return ScopeDownload
+	}
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyHash returns the hash of the api key of the request, which scopes the state the server
+// keeps for the client, such as its idempotency keys and upload sessions.
+func apiKeyHash(r *http.Request) [sha256.Size]byte {
+	return sha256.Sum256([]byte(r.Header.Get(types.HeaderAPIKey)))
+}
+
+// authorizeBatch makes sure the api key of the request may access the batch, any batch is allowed
+// if the server runs without api keys.
+func authorizeBatch(r *http.Request, batchID int) error {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	if key != nil && !key.allowsBatch(batchID) {
+		return fmt.Errorf("%w: %d", errBatchForbidden, batchID)
+	}
+
+	return nil
+}
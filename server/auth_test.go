@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// newTestAuthServer starts a server with api keys: an uploader, a reader of every batch, a reader
+// of batch 1 only, an admin and an admin of batch 1 only. Two batches are uploaded.
+func newTestAuthServer(t *testing.T) string {
+	t.Helper()
+
+	signer, err := sth.NewSigner(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ParseAPIKeys("uploader:upload,reader:download,reader-1:download:1,admin:admin,admin-1:admin:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewAuthenticator(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := httptest.NewServer(NewRouter(RouterConfig{
+		Repository:    storage.NewInMemoryStorage(),
+		Signer:        signer,
+		Authenticator: authenticator,
+	}))
+	t.Cleanup(testServer.Close)
+
+	client := fxmerkle.New(testServer.URL, fxmerkle.WithAPIKey("uploader"))
+	for _, content := range []string{"first", "second"} {
+		files := []fxmerkle.File{fxmerkle.BytesFile("a", []byte(content))}
+		if _, _, err = client.Upload(context.Background(), files); err != nil {
+			t.Fatalf("unable to upload a batch: %s", err)
+		}
+	}
+
+	return testServer.URL
+}
+
+// testRequest sends the request with the api key, if any, and returns the response.
+func testRequest(t *testing.T, method, requestURL, apiKey string) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey != "" {
+		request.Header.Set(types.HeaderAPIKey, apiKey)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = response.Body.Close() })
+
+	return response
+}
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	serverURL := newTestAuthServer(t)
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		apiKey string
+		status int
+	}{
+		{"missing key", http.MethodGet, "/download/1", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "/download/1", "other", http.StatusUnauthorized},
+		{"missing key on listing", http.MethodGet, "/batches", "", http.StatusUnauthorized},
+		{"read with upload scope", http.MethodGet, "/download/1", "uploader", http.StatusForbidden},
+		{"upload with download scope", http.MethodPost, "/upload", "reader", http.StatusForbidden},
+		{"delete with download scope", http.MethodDelete, "/batches/1", "reader", http.StatusForbidden},
+		{"delete with upload scope", http.MethodDelete, "/batches/1", "uploader", http.StatusForbidden},
+		{"read with download scope", http.MethodGet, "/download/1", "reader", http.StatusOK},
+		{"read with admin scope", http.MethodGet, "/download/1?batch=1", "admin", http.StatusOK},
+		{"public tree head", http.MethodGet, "/sth", "", http.StatusOK},
+		{"public tree head of a batch", http.MethodGet, "/sth?batch=2", "", http.StatusOK},
+		{"public tree head with unknown key", http.MethodGet, "/sth", "other", http.StatusOK},
+		{"public key", http.MethodGet, "/pubkey", "", http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			response := testRequest(t, test.method, serverURL+test.path, test.apiKey)
+			if response.StatusCode != test.status {
+				t.Fatalf("%s %s responded %s, expected %d", test.method, test.path, response.Status, test.status)
+			}
+
+			if test.status == http.StatusUnauthorized && response.Header.Get("WWW-Authenticate") != types.HeaderAPIKey {
+				t.Fatalf("401 response asks for %q", response.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthorizeBatch(t *testing.T) {
+	serverURL := newTestAuthServer(t)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/download/1?batch=1", http.StatusOK},
+		{"/proof/1?batch=1", http.StatusOK},
+		{"/multiproof?index=1&batch=1", http.StatusOK},
+		{"/files?batch=1", http.StatusOK},
+		{"/batches/1", http.StatusOK},
+		{"/batches/1/archive", http.StatusOK},
+		// the last batch is batch 2 when none is asked for.
+		{"/download/1", http.StatusForbidden},
+		{"/download/1?batch=2", http.StatusForbidden},
+		{"/proof/1?batch=2", http.StatusForbidden},
+		{"/multiproof?index=1&batch=2", http.StatusForbidden},
+		{"/files?batch=2", http.StatusForbidden},
+		{"/batches/2", http.StatusForbidden},
+		{"/batches/2/archive", http.StatusForbidden},
+		{"/batches/2/dag", http.StatusForbidden},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			response := testRequest(t, http.MethodGet, serverURL+test.path, "reader-1")
+			if response.StatusCode != test.status {
+				t.Fatalf("GET %s responded %s, expected %d", test.path, response.Status, test.status)
+			}
+		})
+	}
+
+	// the batches listing only holds the batches of the key.
+	var batches types.BatchesResponse
+	response := testRequest(t, http.MethodGet, serverURL+"/batches", "reader-1")
+	if err := json.NewDecoder(response.Body).Decode(&batches); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches.Batches) != 1 || batches.Batches[0].ID != 1 {
+		t.Fatalf("batches listing of a key of batch 1 is %+v", batches.Batches)
+	}
+
+	// a batch-restricted admin key may only delete its batches.
+	if response = testRequest(t, http.MethodDelete, serverURL+"/batches/2", "admin-1"); response.StatusCode != http.StatusForbidden {
+		t.Fatalf("DELETE /batches/2 responded %s", response.Status)
+	}
+	if response = testRequest(t, http.MethodDelete, serverURL+"/batches/1", "admin-1"); response.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /batches/1 responded %s", response.Status)
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// NewBatchesHandler lists the stored batches the api key is allowed to access, oldest first.
+func NewBatchesHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		response := types.BatchesResponse{Batches: []types.BatchResponse{}}
+		err := repository.View(r.Context(), func(snapshot storage.Snapshot) error {
+			batches, err := snapshot.RetrieveBatches()
+			if err != nil {
+				return err
+			}
+
+			for _, batch := range batches {
+				if authorizeBatch(r, batch.ID) != nil {
+					continue
+				}
+
+				batchResponse, err := newBatchResponse(snapshot, batch)
+				if err != nil {
+					return err
+				}
+
+				response.Batches = append(response.Batches, batchResponse)
+			}
+
+			return nil
+		})
+		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		if err = httpOkJson(w, response); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// NewBatchHandler describes a stored batch along with its files, or deletes it.
+func NewBatchHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		batchID, err := batchIDFromRequest(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err = authorizeBatch(r, batchID); err != nil {
+			httpError(w, http.StatusForbidden, err)
+
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err = repository.DeleteBatch(r.Context(), batchID); err != nil {
+				httpError(w, storageErrorStatus(err), err)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		var response types.BatchResponse
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) error {
+			batch, err := snapshot.RetrieveBatch(batchID)
+			if err != nil {
+				return err
+			}
+
+			if response, err = newBatchResponse(snapshot, batch); err != nil {
+				return err
+			}
+
+			files, err := snapshot.RetrieveBatchFiles(batch.ID)
+			if err != nil {
+				return err
+			}
+
+			for _, file := range files {
+				response.Files = append(response.Files, types.BatchFile{Name: file.Name, Index: file.Index, Size: file.Size})
+			}
+
+			return nil
+		})
+		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		if err = httpOkJson(w, response); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// newBatchResponse describes the batch without its files.
+func newBatchResponse(snapshot storage.Snapshot, batch storage.Batch) (types.BatchResponse, error) {
+	treeHead, err := snapshot.RetrieveTreeHead(batch.ID)
+	if err != nil {
+		return types.BatchResponse{}, err
+	}
+
+	return types.BatchResponse{
+		ID:         batch.ID,
+		CreatedAt:  batch.CreatedAt,
+		Root:       treeHead.Root,
+		DagRoot:    batch.DagRoot,
+		Ordering:   batch.Ordering,
+		LeafFormat: batch.LeafFormat,
+		Size:       batch.Size,
+		FileCount:  batch.FileCount,
+	}, nil
+}
+
+func NewBatchArchiveHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		batchID, err := batchIDFromRequest(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err = authorizeBatch(r, batchID); err != nil {
+			httpError(w, http.StatusForbidden, err)
+
+			return
+		}
+
+		// the manifest is built from a snapshot, then the blobs are read and written one at a time,
+		// neither holding the snapshot nor the whole batch in memory while the archive is sent.
+		var batch storage.Batch
+		var manifest types.BatchManifest
+		var files []storage.StoredFile
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+			if batch, err = snapshot.RetrieveBatch(batchID); err != nil {
+				return
+			}
+
+			treeHead, err := snapshot.RetrieveTreeHead(batch.ID)
+			if err != nil {
+				return
+			}
+
+			merkleTree, err := snapshot.RetrieveTree(batch.ID)
+			if err != nil {
+				return
+			}
+
+			if files, err = snapshot.RetrieveBatchFiles(batch.ID); err != nil {
+				return
+			}
+
+			manifest = types.BatchManifest{
+				TreeHead:   treeHead,
+				Ordering:   batch.Ordering,
+				LeafFormat: batch.LeafFormat,
+			}
+			for _, file := range files {
+				// indexes start from 1 while tree leaves start from 0.
+				leafHash, err := merkleTree.LeafHash(uint64(file.Index - 1))
+				if err != nil {
+					return err
+				}
+
+				merkleProof, err := merkleTree.ProofByIndex(uint64(file.Index - 1))
+				if err != nil {
+					return err
+				}
+
+				manifest.Files = append(manifest.Files, types.ManifestFile{
+					UploadedFile: types.UploadedFile{
+						Name:  file.Name,
+						Index: file.Index,
+						Hash:  hex.EncodeToString(leafHash),
+					},
+					Mode:        file.Mode,
+					ModTime:     file.ModTime,
+					MerkleProof: *merkleProof,
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		manifestJson, err := json.Marshal(manifest)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="batch-%d.tar"`, batch.ID))
+
+		// the response status is sent along with the first entry, errors can only be logged from here on.
+		tarWriter := tar.NewWriter(w)
+		if err = writeTarEntry(tarWriter, types.ManifestFilename, manifestJson, batch); err != nil {
+			log.Printf("unable to write batch %d archive manifest: %s\n", batch.ID, err)
+
+			return
+		}
+
+		for _, file := range files {
+			// a batch deleted meanwhile cuts the archive short, which the client tells apart.
+			content, err := repository.RetrieveBlob(r.Context(), file.BlobKey)
+			if err != nil {
+				log.Printf("unable to read batch %d archive entry %s: %s\n", batch.ID, file.Name, err)
+
+				return
+			}
+
+			if err = writeTarEntry(tarWriter, file.Name, content, batch); err != nil {
+				log.Printf("unable to write batch %d archive entry %s: %s\n", batch.ID, file.Name, err)
+
+				return
+			}
+		}
+
+		if err = tarWriter.Close(); err != nil {
+			log.Printf("unable to close batch %d archive: %s\n", batch.ID, err)
+		}
+	}
+}
+
+func writeTarEntry(tarWriter *tar.Writer, name string, content []byte, batch storage.Batch) error {
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: batch.CreatedAt,
+	}); err != nil {
+		return err
+	}
+
+	_, err := tarWriter.Write(content)
+
+	return err
+}
+
+func batchIDFromRequest(r *http.Request) (batchID int, err error) {
+	vars := mux.Vars(r)
+	batchIDParam, isBatchIDSet := vars["id"]
+	if !isBatchIDSet {
+		err = errors.New("{id} path param is not passed in")
+
+		return
+	}
+
+	batchID, err = strconv.Atoi(batchIDParam)
+	if err != nil {
+		err = fmt.Errorf("{id} path param must be numeric: %s", err)
+	}
+
+	return
+}
@@ -1,38 +1,171 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
 
 	"github.com/TxCorpi0x/file-upload-merkle/conf"
 	"github.com/TxCorpi0x/file-upload-merkle/server"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
 	"github.com/TxCorpi0x/file-upload-merkle/storage"
 )
 
 const (
-	defaultPort = 8080
+	defaultPort       = 8080
+	defaultStorage    = "memory"
+	defaultSQLitePath = ".runtime/fxmerkle.db"
+	defaultS3Endpoint = "localhost:9000"
+	defaultS3Bucket   = "fxmerkle"
+	shutdownTimeout   = 10 * time.Second
 )
 
 var Cmd = &cobra.Command{
 	Use:   "server",
 	Short: "The fxmerkle server exposes a HTTP API for verifiable files upload & download",
 	Run: func(cmd *cobra.Command, args []string) {
-		//repository := storage.NewInMemoryStorage()
-		repository := storage.NewInMemoryStorage()
+		repository, err := repositoryFromEnv(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		signer, err := signerFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if signer != nil {
+			log.Println("signing tree heads with public key", hex.EncodeToString(signer.PublicKey()))
+		}
+
+		retention := storage.RetentionPolicy{
+			TTL:     conf.EnvDuration("RETENTION_TTL", 0),
+			MaxSize: int64(conf.EnvInt("RETENTION_MAX_SIZE", 0)),
+		}
+		if retention.Enabled() {
+			go server.ExpireBatches(cmd.Context(), repository, retention, conf.EnvDuration("RETENTION_INTERVAL", time.Minute))
+		}
+
+		authenticator, err := authenticatorFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		r := mux.NewRouter()
-		r.HandleFunc("/upload", server.NewUploadHandler(repository))
-		r.HandleFunc("/download/{index}", server.NewDownloadHandler(repository))
-		r.HandleFunc("/proof/{index}", server.NewProofHandler(repository))
+		if authenticator == nil {
+			log.Println("no api keys configured, every endpoint is open")
+		}
+
+		r := server.NewRouter(server.RouterConfig{
+			Repository:       repository,
+			Signer:           signer,
+			Retention:        retention,
+			Authenticator:    authenticator,
+			IdempotencyTTL:   conf.EnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+			UploadSessionTTL: conf.EnvDuration("UPLOAD_SESSION_TTL", time.Hour),
+		})
 
 		port := conf.EnvInt("PORT", defaultPort)
-		log.Println("fxmerkle server started on port", port)
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), r); err != nil {
+		httpServer := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: r}
+
+		// in flight requests are given some time to complete once the server is interrupted.
+		go func() {
+			<-cmd.Context().Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				log.Println("server shutdown:", err)
+			}
+		}()
+
+		certFile, keyFile := conf.EnvStr("TLS_CERT_FILE", ""), conf.EnvStr("TLS_KEY_FILE", "")
+		clientCAFile := conf.EnvStr("TLS_CLIENT_CA_FILE", "")
+		if certFile == "" {
+			if clientCAFile != "" {
+				log.Fatal("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+			}
+
+			log.Println("fxmerkle server started on port", port)
+			err = httpServer.ListenAndServe()
+		} else {
+			if httpServer.TLSConfig, err = server.NewTLSConfig(clientCAFile); err != nil {
+				log.Fatal(err)
+			}
+
+			log.Println("fxmerkle server started with TLS on port", port, "client certificates required:", clientCAFile != "")
+			err = httpServer.ListenAndServeTLS(certFile, keyFile)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
+
+		if closer, ok := repository.(io.Closer); ok {
+			if err = closer.Close(); err != nil {
+				log.Println("unable to close the storage:", err)
+			}
+		}
+
+		log.Println("fxmerkle server stopped")
 	},
 }
+
+// repositoryFromEnv creates the storage selected by STORAGE, memory by default, sqlite stored at
+// SQLITE_PATH or s3 configured by the S3_* variables.
+func repositoryFromEnv(ctx context.Context) (storage.Repository, error) {
+	switch storageName := conf.EnvStr("STORAGE", defaultStorage); storageName {
+	case "memory":
+		return storage.NewInMemoryStorage(), nil
+	case "sqlite":
+		return storage.NewSQLiteStorage(ctx, conf.EnvStr("SQLITE_PATH", defaultSQLitePath))
+	case "s3":
+		return storage.NewS3Storage(ctx, storage.S3Config{
+			Endpoint:  conf.EnvStr("S3_ENDPOINT", defaultS3Endpoint),
+			Region:    conf.EnvStr("S3_REGION", ""),
+			AccessKey: conf.EnvStr("S3_ACCESS_KEY", ""),
+			SecretKey: conf.EnvStr("S3_SECRET_KEY", ""),
+			Secure:    conf.EnvBool("S3_SECURE", false),
+			Bucket:    conf.EnvStr("S3_BUCKET", defaultS3Bucket),
+			Prefix:    conf.EnvStr("S3_PREFIX", ""),
+			PartSize:  uint64(conf.EnvInt("S3_PART_SIZE", 0)),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage %q, expected memory, sqlite or s3", storageName)
+	}
+}
+
+// signerFromEnv loads the optional Ed25519 tree head signing key, either from a file or
+// directly from the hexadecimal seed in the environment.
+func signerFromEnv() (*sth.Signer, error) {
+	if filename := conf.EnvStr("SIGNING_KEY_FILE", ""); filename != "" {
+		return sth.NewSignerFromFile(filename)
+	}
+
+	if hexSeed := conf.EnvStr("SIGNING_KEY", ""); hexSeed != "" {
+		return sth.NewSignerFromHex(hexSeed)
+	}
+
+	return nil, nil
+}
+
+// authenticatorFromEnv loads the optional api keys, either from the json file or from the keys
+// written in the environment, the server requires no api key if there is none.
+func authenticatorFromEnv() (*server.Authenticator, error) {
+	var keys []server.APIKey
+	var err error
+	if filename := conf.EnvStr("API_KEYS_FILE", ""); filename != "" {
+		keys, err = server.LoadAPIKeysFile(filename)
+	} else if value := conf.EnvStr("API_KEYS", ""); value != "" {
+		keys, err = server.ParseAPIKeys(value)
+	}
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	return server.NewAuthenticator(keys)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+var errNotAFile = errors.New("not a file")
+
+// NewDagHandler serves the proof of a path of the batch dag, or the content of the file at the
+// path if asked to.
+func NewDagHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		batchID, err := batchIDFromRequest(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err = authorizeBatch(r, batchID); err != nil {
+			httpError(w, http.StatusForbidden, err)
+
+			return
+		}
+
+		withContent, _ := strconv.ParseBool(r.URL.Query().Get(types.QueryContent))
+		nodePath := mux.Vars(r)["path"]
+
+		var batch storage.Batch
+		var proof dag.PathProof
+		var content []byte
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+			if batch, err = snapshot.RetrieveBatch(batchID); err != nil {
+				return
+			}
+
+			dagRoot, err := snapshot.RetrieveDag(batch.ID)
+			if err != nil {
+				return
+			}
+
+			if dagRoot == nil || batch.DagRoot != dagRoot.CID() {
+				return fmt.Errorf("%w: batch %d has no dag", storage.ErrBatchNotFound, batch.ID)
+			}
+
+			node, nodeProof, err := dagRoot.Lookup(nodePath)
+			if err != nil {
+				return
+			}
+
+			proof = nodeProof
+			if withContent {
+				content, err = dagFileContent(snapshot, batch, node, nodePath)
+			}
+
+			return
+		})
+		if err != nil {
+			httpError(w, dagErrorStatus(err), err)
+
+			return
+		}
+
+		if withContent {
+			_, _ = w.Write(content)
+
+			return
+		}
+
+		if err = httpOkJson(w, types.DagPathResponse{Root: batch.DagRoot, Proof: proof}); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// dagFileContent reads the content of the file node at the path.
+func dagFileContent(snapshot storage.Snapshot, batch storage.Batch, node *dag.Node, nodePath string) ([]byte, error) {
+	if node.Kind != dag.KindFile {
+		return nil, fmt.Errorf("%s is %w", nodePath, errNotAFile)
+	}
+
+	files, err := snapshot.RetrieveBatchFiles(batch.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.Name == nodePath {
+			return snapshot.RetrieveBlob(file.BlobKey)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s is not stored", storage.ErrStoredFileNotFound, nodePath)
+}
+
+// dagErrorStatus is the status of an error reading the dag.
+func dagErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotAFile):
+		return http.StatusBadRequest
+	case errors.Is(err, dag.ErrPathNotFound):
+		return http.StatusNotFound
+	default:
+		return storageErrorStatus(err)
+	}
+}
@@ -1,18 +1,24 @@
 package server
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
-
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
 	"github.com/TxCorpi0x/file-upload-merkle/storage"
 	"github.com/TxCorpi0x/file-upload-merkle/types"
 )
 
+// NewDownloadHandler serves the file at the index of the batch asked for in the query, of the last
+// batch if none is asked for.
 func NewDownloadHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -28,24 +34,61 @@ func NewDownloadHandler(repository storage.Repository) func(http.ResponseWriter,
 			return
 		}
 
-		fileContent, err := repository.RetrieveFileByIndex(r.Context(), index)
-		if err == storage.ErrStoredFileNotFound {
+		batchID, err := batchFromQuery(r.URL.Query())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if withProof, _ := strconv.ParseBool(r.URL.Query().Get(types.QueryWithProof)); withProof {
+			downloadWithProof(w, r, repository, batchID, index)
+
+			return
+		}
+
+		var batch storage.Batch
+		var file storage.StoredFile
+		var fileContent []byte
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+			batchID := snapshotBatch(snapshot, batchID)
+			if err = authorizeBatch(r, batchID); err != nil {
+				return
+			}
+
+			if file, err = snapshot.RetrieveFileByIndex(batchID, index); err != nil {
+				return
+			}
+
+			if batch, err = snapshot.RetrieveBatch(file.BatchID); err != nil {
+				return
+			}
+
+			fileContent, err = snapshot.RetrieveBlob(file.BlobKey)
+
+			return
+		})
+		if errors.Is(err, storage.ErrStoredFileNotFound) {
 			httpError(w, http.StatusNotFound, fmt.Errorf("{index} not found: %d", index))
 
 			return
 		}
 		if err != nil {
-			httpError(w, http.StatusInternalServerError, err)
+			httpError(w, storageErrorStatus(err), err)
 
 			return
 		}
 
-		_, err = w.Write(fileContent.Content)
+		// the file metadata lets clients holding the leaf hash, such as from a multi-proof, verify the file.
+		setFileHeaders(w, batch, file)
+		_, err = w.Write(fileContent)
 
 		return
 	}
 }
 
+// NewProofHandler proves the file at the index of the batch asked for in the query, of the last
+// batch if none is asked for.
 func NewProofHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -61,38 +104,218 @@ func NewProofHandler(repository storage.Repository) func(http.ResponseWriter, *h
 			return
 		}
 
-		merkleTree, err := repository.RetrieveTree(r.Context())
+		batchID, err := batchFromQuery(r.URL.Query())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		// the file and the tree are read from the same snapshot, a concurrent upload can't pair the
+		// file with a tree which doesn't contain it.
+		var merkleProof *merkle.Proof
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) error {
+			batchID := snapshotBatch(snapshot, batchID)
+			if err := authorizeBatch(r, batchID); err != nil {
+				return err
+			}
+
+			merkleTree, err := snapshot.RetrieveTree(batchID)
+			if err != nil {
+				return err
+			}
+
+			fileByIndex, err := snapshot.RetrieveFileByIndex(batchID, index)
+			if err != nil {
+				return err
+			}
+
+			// indexes start from 1 while tree leaves start from 0.
+			merkleProof, err = merkleTree.ProofByIndex(uint64(fileByIndex.Index - 1))
+
+			return err
+		})
 		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		if err = httpOkJson(w, types.MerkleProofResponse{MerkleProof: *merkleProof}); err != nil {
 			httpError(w, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+}
+
+// NewMultiProofHandler proves the files at the indexes given in the query at once, along with
+// their leaf hashes, of the batch asked for or the last batch.
+func NewMultiProofHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
 
 			return
 		}
 
-		fileByIndex, err := repository.RetrieveFileByIndex(r.Context(), index)
+		indexParams := r.URL.Query()[types.QueryIndex]
+		if len(indexParams) == 0 {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("%s query param is not passed in", types.QueryIndex))
+
+			return
+		}
+
+		leafIndexes := make([]uint64, len(indexParams))
+		for i, indexParam := range indexParams {
+			index, err := strconv.ParseUint(indexParam, 10, 64)
+			if err != nil || index < 1 {
+				httpError(w, http.StatusBadRequest, fmt.Errorf(
+					"%s query param must be a number starting from 1: %q", types.QueryIndex, indexParam,
+				))
+
+				return
+			}
+
+			// indexes start from 1 while tree leaves start from 0.
+			leafIndexes[i] = index - 1
+		}
+
+		batchID, err := batchFromQuery(r.URL.Query())
 		if err != nil {
-			statusCode := http.StatusInternalServerError
-			if errors.Is(err, storage.ErrStoredFileNotFound) {
-				statusCode = http.StatusNotFound
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		var merkleTree *merkle.Tree
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+			batchID := snapshotBatch(snapshot, batchID)
+			if err = authorizeBatch(r, batchID); err != nil {
+				return
 			}
 
-			httpError(w, statusCode, err)
+			merkleTree, err = snapshot.RetrieveTree(batchID)
 
 			return
+		})
+		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		for _, leafIndex := range leafIndexes {
+			if merkleTree == nil || leafIndex >= uint64(merkleTree.Size) {
+				httpError(w, http.StatusNotFound, fmt.Errorf("{index} not found: %d", leafIndex+1))
+
+				return
+			}
 		}
 
-		merkleProof, err := merkleTree.Proof(fileByIndex.Content)
+		multiProof, err := merkleTree.MultiProof(leafIndexes)
 		if err != nil {
 			httpError(w, http.StatusInternalServerError, err)
 
 			return
 		}
 
-		if err = httpOkJson(w, types.MerkleProofResponse{MerkleProof: *merkleProof}); err != nil {
+		leafHashes := make([]string, len(multiProof.Indexes))
+		for i, leafIndex := range multiProof.Indexes {
+			leafHash, _ := merkleTree.LeafHash(leafIndex)
+			leafHashes[i] = hex.EncodeToString(leafHash)
+		}
+
+		if err = httpOkJson(w, types.MultiProofResponse{
+			Root:       merkleTree.RootHex(),
+			MultiProof: *multiProof,
+			LeafHashes: leafHashes,
+		}); err != nil {
 			httpError(w, http.StatusInternalServerError, err)
 		}
+	}
+}
+
+// downloadWithProof writes the file content of the batch, the last one if 0, along with its merkle
+// proof and metadata in the response headers, the file and the tree are read from the same
+// snapshot and the file is checked against the tree leaf.
+func downloadWithProof(w http.ResponseWriter, r *http.Request, repository storage.Repository, batchID, index int) {
+	var merkleTree *merkle.Tree
+	var batch storage.Batch
+	var file storage.StoredFile
+	var content []byte
+	err := repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+		batchID := snapshotBatch(snapshot, batchID)
+		if err = authorizeBatch(r, batchID); err != nil {
+			return
+		}
+
+		if merkleTree, err = snapshot.RetrieveTree(batchID); err != nil {
+			return
+		}
+
+		if merkleTree == nil || index < 1 || index > merkleTree.Size {
+			return fmt.Errorf("%w: {index} not found: %d", storage.ErrStoredFileNotFound, index)
+		}
+
+		if batch, err = snapshot.RetrieveBatch(batchID); err != nil {
+			return
+		}
+
+		if file, err = snapshot.RetrieveFileByIndex(batch.ID, index); err != nil {
+			return
+		}
+
+		content, err = snapshot.RetrieveBlob(file.BlobKey)
+
+		return
+	})
+	if err != nil {
+		httpError(w, storageErrorStatus(err), err)
+
+		return
+	}
+
+	// indexes start from 1 while tree leaves start from 0.
+	leafIndex := uint64(index - 1)
+	merkleProof, err := merkleTree.ProofByIndex(leafIndex)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
 
 		return
 	}
+
+	hasher := hash.NewSha256()
+	leafHash, _ := merkleTree.LeafHash(leafIndex)
+	if !bytes.Equal(hasher.Hash(batch.LeafFormat.Data(file.Leaf(content), hasher)), leafHash) {
+		httpError(w, http.StatusConflict, fmt.Errorf("file at index %d does not belong to the current tree", index))
+
+		return
+	}
+
+	proofHashes := make([]string, len(merkleProof.Hashes))
+	for i, h := range merkleProof.Hashes {
+		proofHashes[i] = hex.EncodeToString(h)
+	}
+
+	w.Header().Set(types.HeaderMerkleRoot, merkleTree.RootHex())
+	w.Header().Set(types.HeaderMerkleTreeSize, strconv.Itoa(merkleTree.Size))
+	w.Header().Set(types.HeaderMerkleIndex, strconv.FormatUint(merkleProof.Index, 10))
+	w.Header().Set(types.HeaderMerkleProof, strings.Join(proofHashes, ","))
+	setFileHeaders(w, batch, file)
+
+	_, _ = w.Write(content)
+}
+
+// setFileHeaders sets the leaf format of the batch and the file metadata the leaf commits to in
+// the response headers.
+func setFileHeaders(w http.ResponseWriter, batch storage.Batch, file storage.StoredFile) {
+	w.Header().Set(types.HeaderMerkleLeafFormat, string(batch.LeafFormat))
+	w.Header().Set(types.HeaderFileName, file.Name)
+	if batch.LeafFormat == merkle.LeafFormatMetadataAttrs {
+		w.Header().Set(types.HeaderFileMode, strconv.FormatUint(uint64(file.Mode), 8))
+		w.Header().Set(types.HeaderFileModTime, strconv.FormatInt(file.ModTime, 10))
+	}
 }
 
 func indexFromRequest(r *http.Request) (index int, err error) {
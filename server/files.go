@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+const (
+	defaultFilesLimit = 100
+	maxFilesLimit     = 1000
+)
+
+// NewFilesHandler lists the files of a batch a page at a time, the files of the last batch if no
+// batch is asked for. The cursor pins the batch, so the pages keep listing the same batch.
+func NewFilesHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		batchID, fromIndex, limit, err := filesPageFromQuery(r.URL.Query())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		var response types.FilesResponse
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) error {
+			batchID = snapshotBatch(snapshot, batchID)
+
+			if err := authorizeBatch(r, batchID); err != nil {
+				return err
+			}
+
+			merkleTree, err := snapshot.RetrieveTree(batchID)
+			if err != nil {
+				return err
+			}
+
+			// one more file tells whether there is a next page.
+			files, err := snapshot.RetrieveFiles(batchID, fromIndex, limit+1)
+			if err != nil {
+				return err
+			}
+
+			if len(files) > limit {
+				response.NextCursor = encodeFilesCursor(batchID, files[limit].Index)
+				files = files[:limit]
+			}
+
+			response.BatchID = batchID
+			response.Files = make([]types.FileEntry, len(files))
+			for i, file := range files {
+				// indexes start from 1 while tree leaves start from 0.
+				leafHash, err := merkleTree.LeafHash(uint64(file.Index - 1))
+				if err != nil {
+					return err
+				}
+
+				response.Files[i] = types.FileEntry{
+					Name:        file.Name,
+					Index:       file.Index,
+					Size:        file.Size,
+					Hash:        hex.EncodeToString(leafHash),
+					ContentType: storedContentType(file),
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			httpError(w, storageErrorStatus(err), err)
+
+			return
+		}
+
+		if err = httpOkJson(w, response); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// filesPageFromQuery returns the batch, first index and size of the asked page, the batch is 0 if
+// the last batch is asked for.
+func filesPageFromQuery(query url.Values) (batchID, fromIndex, limit int, err error) {
+	if batchID, err = batchFromQuery(query); err != nil {
+		return
+	}
+
+	fromIndex = 1
+	if cursor := query.Get(types.QueryCursor); cursor != "" {
+		var cursorBatchID int
+		if cursorBatchID, fromIndex, err = decodeFilesCursor(cursor); err != nil {
+			return
+		}
+
+		if batchID != 0 && batchID != cursorBatchID {
+			err = fmt.Errorf("the cursor belongs to batch %d, not %d", cursorBatchID, batchID)
+
+			return
+		}
+
+		batchID = cursorBatchID
+	}
+
+	limit = defaultFilesLimit
+	if limitParam := query.Get(types.QueryLimit); limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 1 || limit > maxFilesLimit {
+			err = fmt.Errorf("{%s} query param must be a number from 1 to %d", types.QueryLimit, maxFilesLimit)
+		}
+	}
+
+	return
+}
+
+// batchFromQuery returns the batch asked for in the query, 0 if the last batch is asked for.
+func batchFromQuery(query url.Values) (batchID int, err error) {
+	if batchParam := query.Get(types.QueryBatch); batchParam != "" {
+		if batchID, err = strconv.Atoi(batchParam); err != nil || batchID < 1 {
+			err = fmt.Errorf("{%s} query param must be a number starting from 1", types.QueryBatch)
+		}
+	}
+
+	return
+}
+
+// snapshotBatch returns the batch asked for, the last batch of the snapshot if it is 0.
+func snapshotBatch(snapshot storage.Snapshot, batchID int) int {
+	if batchID == 0 {
+		return snapshot.Version()
+	}
+
+	return batchID
+}
+
+// encodeFilesCursor returns the opaque cursor of the page starting at the index of the batch.
+func encodeFilesCursor(batchID, fromIndex int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", batchID, fromIndex)))
+}
+
+func decodeFilesCursor(cursor string) (batchID, fromIndex int, err error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		_, err = fmt.Sscanf(string(decoded), "%d:%d", &batchID, &fromIndex)
+	}
+	if err != nil || batchID < 1 || fromIndex < 1 {
+		err = fmt.Errorf("invalid {%s} query param", types.QueryCursor)
+	}
+
+	return
+}
+
+// storedContentType returns the content type of the file, guessed by its extension for the files
+// stored before the content type was kept.
+func storedContentType(file storage.StoredFile) string {
+	if file.ContentType != "" {
+		return file.ContentType
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(file.Name)); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
 )
 
 func httpError(w http.ResponseWriter, statusCode int, err error) {
@@ -16,3 +19,17 @@ func httpOkJson(w http.ResponseWriter, payload any) (err error) {
 
 	return json.NewEncoder(w).Encode(payload)
 }
+
+// storageErrorStatus is the status of a storage error, not found if what was read is not stored
+// and forbidden if the api key doesn't allow the batch.
+func storageErrorStatus(err error) int {
+	if errors.Is(err, errBatchForbidden) {
+		return http.StatusForbidden
+	}
+
+	if errors.Is(err, storage.ErrStoredFileNotFound) || errors.Is(err, storage.ErrBatchNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+var errIdempotencyKeyReused = errors.New("idempotency key was used for another upload")
+
+// maxIdempotencyKeyLength bounds the keys kept in memory.
+const maxIdempotencyKeyLength = 255
+
+// IdempotentUploads remembers the responses of the uploads sent with an idempotency key for a
+// while, an upload sent again with the same key, such as a retry after a lost response, gets the
+// response of the first one instead of storing the batch twice. Keys are scoped by api key and
+// kept in memory only, they don't survive a restart.
+type IdempotentUploads struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*idempotentUpload
+}
+
+// idempotentUpload is an upload in progress, or done and remembered until it expires.
+type idempotentUpload struct {
+	fingerprint string
+	// done is closed once the upload is stored, or failed and forgotten.
+	done      chan struct{}
+	response  types.UploadedFilesResponse
+	expiresAt time.Time
+}
+
+// NewIdempotentUploads remembers the uploads for the ttl, nil if the ttl isn't positive, which
+// stores every upload as sent.
+func NewIdempotentUploads(ttl time.Duration) *IdempotentUploads {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &IdempotentUploads{ttl: ttl, uploads: make(map[string]*idempotentUpload)}
+}
+
+// do stores the upload unless an upload with the same key is remembered, whose response is then
+// replayed. An upload sent while the first one is in progress waits for it, a failed upload is
+// forgotten so it can be sent again. Reusing a key for an upload with another fingerprint is an
+// error.
+func (u *IdempotentUploads) do(
+	ctx context.Context,
+	key, fingerprint string,
+	store func() (types.UploadedFilesResponse, error),
+) (response types.UploadedFilesResponse, replayed bool, err error) {
+	if u == nil || key == "" {
+		response, err = store()
+
+		return
+	}
+
+	for {
+		now := time.Now()
+
+		u.mu.Lock()
+		u.expire(now)
+		upload, found := u.uploads[key]
+		if !found {
+			upload = &idempotentUpload{fingerprint: fingerprint, done: make(chan struct{})}
+			u.uploads[key] = upload
+		}
+		u.mu.Unlock()
+
+		if !found {
+			// the slot is released however the upload ends, even by a panic, so the uploads
+			// waiting for it never hang.
+			stored := false
+			defer func() {
+				u.mu.Lock()
+				if stored {
+					upload.response = response
+					upload.expiresAt = time.Now().Add(u.ttl)
+				} else if u.uploads[key] == upload {
+					delete(u.uploads, key)
+				}
+				close(upload.done)
+				u.mu.Unlock()
+			}()
+
+			response, err = store()
+			stored = err == nil
+
+			return
+		}
+
+		if upload.fingerprint != fingerprint {
+			err = errIdempotencyKeyReused
+
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+
+			return
+		case <-upload.done:
+		}
+
+		u.mu.Lock()
+		stored := u.uploads[key] == upload
+		u.mu.Unlock()
+
+		if stored {
+			return upload.response, true, nil
+		}
+		// the first upload failed, this one is stored in its place.
+	}
+}
+
+// expire forgets the uploads remembered for longer than the ttl, the ones in progress are kept.
+func (u *IdempotentUploads) expire(now time.Time) {
+	for key, upload := range u.uploads {
+		if !upload.expiresAt.IsZero() && now.After(upload.expiresAt) {
+			delete(u.uploads, key)
+		}
+	}
+}
+
+// idempotencyKeyFromRequest returns the idempotency key of the request scoped by its api key, so
+// clients can't replay the uploads of one another, or an empty string if none was sent.
+func idempotencyKeyFromRequest(r *http.Request) (string, error) {
+	idempotencyKey := r.Header.Get(types.HeaderIdempotencyKey)
+	if idempotencyKey == "" {
+		return "", nil
+	}
+
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("%s header is longer than %d bytes", types.HeaderIdempotencyKey, maxIdempotencyKeyLength)
+	}
+
+	keyHash := apiKeyHash(r)
+
+	return hex.EncodeToString(keyHash[:]) + ":" + idempotencyKey, nil
+}
+
+// fingerprint identifies the content of the upload, the same key must not be used for an upload
+// of other files or options.
+func (u receivedUpload) fingerprint() string {
+	hasher := sha256.New()
+	_, _ = fmt.Fprintf(hasher, "%s\x00%s\x00%t\x00", u.ordering, u.leafFormat, u.withDag)
+	for _, file := range u.files {
+		_ = binary.Write(hasher, binary.BigEndian, uint64(len(file.name)))
+		hasher.Write([]byte(file.name))
+		hasher.Write(file.leafHash)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+func TestIdempotentUploadsReleaseSlotOnPanic(t *testing.T) {
+	uploads := NewIdempotentUploads(time.Hour)
+
+	func() {
+		defer func() { _ = recover() }()
+
+		_, _, _ = uploads.do(context.Background(), "key", "fingerprint", func() (types.UploadedFilesResponse, error) {
+			panic("store failed")
+		})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	want := types.UploadedFilesResponse{Ordering: types.OrderingPath}
+	response, replayed, err := uploads.do(ctx, "key", "fingerprint", func() (types.UploadedFilesResponse, error) {
+		return want, nil
+	})
+	if err != nil {
+		t.Fatalf("upload retried after a panic: %s", err)
+	}
+	if replayed || response.Ordering != want.Ordering {
+		t.Fatalf("upload retried after a panic got replayed=%t response=%+v", replayed, response)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+)
+
+// ExpireBatches applies the retention policy every interval until the context is done.
+func ExpireBatches(ctx context.Context, repository storage.Repository, policy storage.RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expireBatches(ctx, repository, policy)
+		}
+	}
+}
+
+// expireBatches applies the retention policy, the errors are only logged.
+func expireBatches(ctx context.Context, repository storage.Repository, policy storage.RetentionPolicy) {
+	expired, err := policy.Expire(ctx, repository, time.Now())
+	if len(expired) > 0 {
+		log.Printf("expired batches %v\n", expired)
+	}
+	if err != nil {
+		log.Printf("unable to apply the retention policy: %s\n", err)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+)
+
+// RouterConfig holds what the endpoints of the server are served with.
+type RouterConfig struct {
+	Repository storage.Repository
+	// Signer signs the tree heads of the uploads, they are left unsigned if nil.
+	Signer    *sth.Signer
+	Retention storage.RetentionPolicy
+	// Authenticator checks the api key of the requests, every endpoint is open if nil.
+	Authenticator    *Authenticator
+	IdempotencyTTL   time.Duration
+	UploadSessionTTL time.Duration
+}
+
+// NewRouter returns the router of the server endpoints.
+func NewRouter(config RouterConfig) *mux.Router {
+	repository := config.Repository
+
+	r := mux.NewRouter()
+	if config.Authenticator != nil {
+		r.Use(config.Authenticator.Middleware)
+	}
+	r.HandleFunc("/upload", NewUploadHandler(
+		repository,
+		config.Signer,
+		config.Retention,
+		NewIdempotentUploads(config.IdempotencyTTL),
+	))
+	uploadSessions := NewUploadSessions(repository, config.Signer, config.Retention, config.UploadSessionTTL)
+	r.HandleFunc("/uploads", NewUploadSessionsHandler(uploadSessions))
+	r.HandleFunc("/uploads/{id}", NewUploadSessionHandler(uploadSessions))
+	r.HandleFunc("/uploads/{id}/files/{position}", NewUploadSessionFileHandler(uploadSessions))
+	r.HandleFunc("/uploads/{id}/commit", NewUploadSessionCommitHandler(uploadSessions))
+	r.HandleFunc("/download/{index}", NewDownloadHandler(repository))
+	r.HandleFunc("/proof/{index}", NewProofHandler(repository))
+	r.HandleFunc("/multiproof", NewMultiProofHandler(repository))
+	r.HandleFunc("/files", NewFilesHandler(repository))
+	r.HandleFunc("/batches", NewBatchesHandler(repository))
+	r.HandleFunc("/batches/{id}", NewBatchHandler(repository))
+	r.HandleFunc("/batches/{id}/archive", NewBatchArchiveHandler(repository))
+	r.HandleFunc("/batches/{id}/dag", NewDagHandler(repository))
+	r.HandleFunc("/batches/{id}/dag/{path:.+}", NewDagHandler(repository))
+	r.HandleFunc("/sth", NewTreeHeadHandler(repository))
+	r.HandleFunc("/pubkey", NewPublicKeyHandler(config.Signer))
+
+	return r
+}
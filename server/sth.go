@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+// NewTreeHeadHandler serves the signed tree head of the batch asked for in the query, of the last
+// batch if none is asked for.
+func NewTreeHeadHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		batchID, err := batchFromQuery(r.URL.Query())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		var treeHead sth.SignedTreeHead
+		err = repository.View(r.Context(), func(snapshot storage.Snapshot) (err error) {
+			treeHead, err = snapshot.RetrieveTreeHead(snapshotBatch(snapshot, batchID))
+
+			return
+		})
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if errors.Is(err, storage.ErrTreeHeadNotFound) {
+				statusCode = http.StatusNotFound
+			}
+
+			httpError(w, statusCode, err)
+
+			return
+		}
+
+		if err = httpOkJson(w, types.TreeHeadResponse{TreeHead: treeHead}); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func NewPublicKeyHandler(signer *sth.Signer) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		if signer == nil {
+			httpError(w, http.StatusNotFound, errors.New("tree head signing is not configured"))
+
+			return
+		}
+
+		if err := httpOkJson(w, types.PublicKeyResponse{
+			PublicKey: hex.EncodeToString(signer.PublicKey()),
+			Algorithm: sth.SignatureAlgorithm,
+		}); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"crypto/tls"
+
+	"github.com/TxCorpi0x/file-upload-merkle/conf"
+)
+
+// NewTLSConfig returns the TLS config of the server. If a client CA bundle is given the clients
+// must present a certificate signed by one of its CAs (mutual TLS).
+func NewTLSConfig(clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	clientCAs, err := conf.CertPoolFromFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/fxmerkle"
+)
+
+// testCertificate is a generated certificate along with the files of its certificate and key.
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate generates a certificate signed by the parent, self signed if there is none,
+// a CA if asked for, valid for localhost otherwise.
+func newTestCertificate(t *testing.T, name string, isCA bool, parent *testCertificate) testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if isCA {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	generated := testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePem(t, generated.certFile, "CERTIFICATE", der)
+	writePem(t, generated.keyFile, "EC PRIVATE KEY", keyDer)
+
+	return generated
+}
+
+func writePem(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer starts a server presenting the certificate with the TLS config of the server,
+// requiring client certificates signed by the client CA if one is given.
+func startTLSServer(t *testing.T, serverCert testCertificate, clientCAFile string) *httptest.Server {
+	t.Helper()
+
+	tlsConfig, err := NewTLSConfig(clientCAFile)
+	if err != nil {
+		t.Fatalf("unable to create the server TLS config: %s", err)
+	}
+
+	certificate, err := tls.LoadX509KeyPair(serverCert.certFile, serverCert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = tlsConfig
+	// the handshake errors of the rejected clients are expected.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// tlsGet sends a request to the server with the TLS config of the client.
+func tlsGet(t *testing.T, url, caFile, certFile, keyFile string) error {
+	t.Helper()
+
+	tlsConfig, err := fxmerkle.NewTLSConfig(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unable to create the client TLS config: %s", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("server answered %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCertificate(t, "ca", true, nil)
+	otherCA := newTestCertificate(t, "other-ca", true, nil)
+	serverCert := newTestCertificate(t, "server", false, &ca)
+
+	server := startTLSServer(t, serverCert, "")
+
+	if err := tlsGet(t, server.URL, ca.certFile, "", ""); err != nil {
+		t.Fatalf("client trusting the server CA is rejected: %s", err)
+	}
+
+	if err := tlsGet(t, server.URL, otherCA.certFile, "", ""); err == nil {
+		t.Fatal("client trusting another CA accepted the server certificate")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCertificate(t, "ca", true, nil)
+	otherCA := newTestCertificate(t, "other-ca", true, nil)
+	serverCert := newTestCertificate(t, "server", false, &ca)
+	clientCert := newTestCertificate(t, "client", false, &ca)
+	otherClientCert := newTestCertificate(t, "other-client", false, &otherCA)
+
+	server := startTLSServer(t, serverCert, ca.certFile)
+
+	if err := tlsGet(t, server.URL, ca.certFile, clientCert.certFile, clientCert.keyFile); err != nil {
+		t.Fatalf("client with a certificate of the client CA is rejected: %s", err)
+	}
+
+	if err := tlsGet(t, server.URL, ca.certFile, "", ""); err == nil {
+		t.Fatal("client without a certificate is accepted")
+	}
+
+	if err := tlsGet(t, server.URL, ca.certFile, otherClientCert.certFile, otherClientCert.keyFile); err == nil {
+		t.Fatal("client with a certificate of another CA is accepted")
+	}
+}
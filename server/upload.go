@@ -1,18 +1,40 @@
 package server
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	gohash "hash"
 	"io"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
 	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
 	"github.com/TxCorpi0x/file-upload-merkle/storage"
 	"github.com/TxCorpi0x/file-upload-merkle/types"
 )
 
-func NewUploadHandler(repository storage.Repository) func(http.ResponseWriter, *http.Request) {
+// errNoFiles is returned for an upload without any file, there is no tree of no leaves.
+var errNoFiles = errors.New("no files were uploaded")
+
+// NewUploadHandler stores the uploaded files as a new batch, then expires the batches the retention
+// policy no longer keeps. Uploads sent with an idempotency key are stored once.
+func NewUploadHandler(
+	repository storage.Repository,
+	signer *sth.Signer,
+	retention storage.RetentionPolicy,
+	idempotentUploads *IdempotentUploads,
+) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
@@ -27,67 +49,466 @@ func NewUploadHandler(repository storage.Repository) func(http.ResponseWriter, *
 			return
 		}
 
-		if err := repository.DeleteAllFiles(r.Context()); err != nil {
-			httpError(w, http.StatusInternalServerError, fmt.Errorf("error while resetting storage: %s", err))
+		ordering, err := types.ParseOrdering(r.FormValue(types.FormFieldOrdering))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
 
 			return
 		}
 
-		var uploadedFiles []types.UploadedFile
-		var blocks [][]byte
+		leafFormat, err := merkle.ParseLeafFormat(r.FormValue(types.FormFieldLeafFormat))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
 
-		files := r.MultipartForm.File["files"]
-		for _, fileHeader := range files {
-			file, err := fileHeader.Open()
+			return
+		}
+
+		withDag := false
+		if dagValue := r.FormValue(types.FormFieldDag); dagValue != "" {
+			if withDag, err = strconv.ParseBool(dagValue); err != nil {
+				httpError(w, http.StatusBadRequest, fmt.Errorf("invalid %s form field: %s", types.FormFieldDag, err))
+
+				return
+			}
+		}
+
+		// read and validate the files before staging the batch, a bad request shouldn't stage anything.
+		fileHeaders := r.MultipartForm.File["files"]
+		if len(fileHeaders) == 0 {
+			httpError(w, http.StatusBadRequest, errNoFiles)
+
+			return
+		}
+
+		// the files are digested here and read again from the parsed form once they are stored.
+		files := make([]receivedFile, len(fileHeaders))
+		for i, fileHeader := range fileHeaders {
+			fileLeaf, err := fileLeafFromHeader(fileHeader)
 			if err != nil {
-				httpError(w, http.StatusBadRequest, fmt.Errorf("unable to open file: %s", err))
+				httpError(w, http.StatusBadRequest, err)
 
 				return
 			}
-			_ = file.Close()
 
-			data, err := io.ReadAll(file)
+			file, err := fileHeader.Open()
 			if err != nil {
-				httpError(w, http.StatusBadRequest, fmt.Errorf("unable to read file: %s", err))
+				httpError(w, http.StatusBadRequest, fmt.Errorf("unable to open file: %s", err))
 
 				return
 			}
 
-			i, err := repository.StoreFile(r.Context(), storage.StoredFile{
-				Name:    fileHeader.Filename,
-				Content: data,
-			})
+			digest := newContentDigest(withDag)
+			_, err = io.Copy(digest, file)
+			_ = file.Close()
 			if err != nil {
-				httpError(w, http.StatusInternalServerError, err)
+				httpError(w, http.StatusBadRequest, fmt.Errorf("unable to read file: %s", err))
 
 				return
 			}
 
-			uploadedFiles = append(uploadedFiles, types.UploadedFile{
-				Name:  fileHeader.Filename,
-				Index: i,
-			})
+			files[i] = digest.receivedFile(fileLeaf, leafFormat)
+		}
+
+		upload := receivedUpload{ordering: ordering, leafFormat: leafFormat, withDag: withDag, files: files}
+		if upload.dagRoot, err = buildDag(upload); err != nil {
+			httpError(w, http.StatusBadRequest, err)
 
-			blocks = append(blocks, data)
+			return
 		}
 
-		merkleTree, err := merkle.NewTree(blocks, hash.NewSha256())
+		idempotencyKey, err := idempotencyKeyFromRequest(r)
 		if err != nil {
-			httpError(w, http.StatusInternalServerError, err)
+			httpError(w, http.StatusBadRequest, err)
 
 			return
 		}
 
-		if err = repository.StoreTree(r.Context(), merkleTree); err != nil {
-			httpError(w, http.StatusInternalServerError, fmt.Errorf("unable to store the merkle tree: %s", err))
+		// an upload sent again with the same idempotency key gets the response of the first one.
+		response, replayed, err := idempotentUploads.do(
+			r.Context(),
+			idempotencyKey,
+			upload.fingerprint(),
+			func() (types.UploadedFilesResponse, error) {
+				return storeUpload(r.Context(), repository, signer, upload, fileHeaders)
+			},
+		)
+		if errors.Is(err, errIdempotencyKeyReused) {
+			httpError(w, http.StatusUnprocessableEntity, err)
 
 			return
 		}
+		if errors.Is(err, errNoFiles) {
+			httpError(w, http.StatusBadRequest, err)
 
-		if err := httpOkJson(w, types.UploadedFilesResponse{UploadedFiles: uploadedFiles}); err != nil {
+			return
+		}
+		if err != nil {
 			httpError(w, http.StatusInternalServerError, err)
 
 			return
 		}
+
+		if replayed {
+			w.Header().Set(types.HeaderIdempotentReplayed, "true")
+		} else {
+			expireBatches(context.WithoutCancel(r.Context()), repository, retention)
+		}
+
+		if err := httpOkJson(w, response); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+
+			return
+		}
+	}
+}
+
+// receivedFile is an uploaded file as committed to by its tree leaf, its content is stored as the
+// blob of the key.
+type receivedFile struct {
+	name        string
+	blobKey     string
+	size        int64
+	contentType string
+	mode        uint32
+	modTime     int64
+	leafHash    []byte
+	// dagHash is the hash of the file node of the dag, if the upload comes with a dag.
+	dagHash hash.Hash
+}
+
+// sniffLen is the length of the content start its type is detected from.
+const sniffLen = 512
+
+// contentDigest computes what the tree leaf of a file commits to from the file content written to
+// it, along with the dag hash and content type of the file, so the content can be streamed.
+type contentDigest struct {
+	hasher      hash.Hasher
+	contentHash gohash.Hash
+	dagHasher   *dag.FileHasher
+	size        int64
+	head        []byte
+}
+
+func newContentDigest(withDag bool) *contentDigest {
+	hasher := hash.NewSha256()
+	digest := &contentDigest{hasher: hasher, contentHash: hasher.New()}
+	if withDag {
+		digest.dagHasher = dag.NewFileHasher(hasher)
+	}
+
+	return digest
+}
+
+func (d *contentDigest) Write(p []byte) (int, error) {
+	_, _ = d.contentHash.Write(p)
+	if d.dagHasher != nil {
+		_, _ = d.dagHasher.Write(p)
 	}
+
+	if len(d.head) < sniffLen {
+		d.head = append(d.head, p[:min(len(p), sniffLen-len(d.head))]...)
+	}
+	d.size += int64(len(p))
+
+	return len(p), nil
+}
+
+// receivedFile returns the received file of the leaf with the content written to the digest, its
+// content is left to be stored.
+func (d *contentDigest) receivedFile(fileLeaf merkle.FileLeaf, leafFormat merkle.LeafFormat) receivedFile {
+	// attributes are only kept if they are committed to.
+	if leafFormat != merkle.LeafFormatMetadataAttrs {
+		fileLeaf.Mode, fileLeaf.ModTime = 0, 0
+	}
+
+	file := receivedFile{
+		name: fileLeaf.Path,
+		size: d.size,
+		// the part content type is left out, clients send every file as an octet stream.
+		contentType: detectContentType(fileLeaf.Path, d.head),
+		mode:        fileLeaf.Mode,
+		modTime:     fileLeaf.ModTime,
+		leafHash: leafFormat.LeafHash(merkle.FileDigest{
+			Path:        fileLeaf.Path,
+			Size:        d.size,
+			ContentHash: d.contentHash.Sum(nil),
+			Mode:        fileLeaf.Mode,
+			ModTime:     fileLeaf.ModTime,
+		}, d.hasher),
+	}
+	if d.dagHasher != nil {
+		file.dagHash, _ = d.dagHasher.Sum()
+	}
+
+	return file
+}
+
+// bodyReader keeps the error reading the request body, to tell it apart from a storage error.
+type bodyReader struct {
+	io.Reader
+	err error
+}
+
+func (r *bodyReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+
+	return
+}
+
+// receivedUpload is an upload as read and validated from the request, before it is stored.
+type receivedUpload struct {
+	ordering   types.Ordering
+	leafFormat merkle.LeafFormat
+	withDag    bool
+	dagRoot    *dag.Node
+	files      []receivedFile
+}
+
+// buildDag builds the dag of the uploaded files, nil if the upload comes without one.
+func buildDag(upload receivedUpload) (*dag.Node, error) {
+	if !upload.withDag {
+		return nil, nil
+	}
+
+	dagFiles := make([]dag.File, len(upload.files))
+	for i, file := range upload.files {
+		dagFiles[i] = dag.File{Path: file.name, Hash: file.dagHash, Size: file.size}
+	}
+
+	dagRoot, err := dag.Build(dagFiles, hash.NewSha256())
+	if err != nil {
+		return nil, fmt.Errorf("unable to build the dag: %s", err)
+	}
+
+	return dagRoot, nil
+}
+
+// storeUpload stores the contents of the uploaded files, read from the parts of the parsed form, as
+// blobs, then the upload as a new batch.
+func storeUpload(
+	ctx context.Context,
+	repository storage.Repository,
+	signer *sth.Signer,
+	upload receivedUpload,
+	fileHeaders []*multipart.FileHeader,
+) (response types.UploadedFilesResponse, err error) {
+	if len(upload.files) == 0 {
+		err = errNoFiles
+
+		return
+	}
+
+	// the blobs are held until the staged files referencing them are committed, the cleanup
+	// runs even if the request is canceled midway.
+	cleanupCtx := context.WithoutCancel(ctx)
+	upload.files = slices.Clone(upload.files)
+	blobKeys := make([]string, len(upload.files))
+	for i, fileHeader := range fileHeaders {
+		if blobKeys[i], err = storeFileBlob(ctx, repository, fileHeader); err != nil {
+			releaseBlobs(cleanupCtx, repository, blobKeys[:i])
+			err = fmt.Errorf("unable to store the file content: %s", err)
+
+			return
+		}
+
+		upload.files[i].blobKey = blobKeys[i]
+	}
+	defer releaseBlobs(cleanupCtx, repository, blobKeys)
+
+	return commitUpload(ctx, repository, signer, upload)
+}
+
+// storeFileBlob stores the content of the file part as a blob.
+func storeFileBlob(ctx context.Context, repository storage.Repository, fileHeader *multipart.FileHeader) (string, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	return repository.StoreBlob(ctx, file)
+}
+
+// commitUpload stores the upload, whose blobs are already stored and held by the caller, as a new
+// batch and returns the response to the upload.
+func commitUpload(
+	ctx context.Context,
+	repository storage.Repository,
+	signer *sth.Signer,
+	upload receivedUpload,
+) (response types.UploadedFilesResponse, err error) {
+	if len(upload.files) == 0 {
+		err = errNoFiles
+
+		return
+	}
+
+	// nothing of the batch is visible until committed, a failed upload leaves the stored batches untouched.
+	stage, err := repository.StageBatch(ctx, newBatch(upload.ordering, upload.leafFormat, upload.dagRoot))
+	if err != nil {
+		err = fmt.Errorf("unable to stage a batch: %s", err)
+
+		return
+	}
+	defer func() {
+		if err := stage.Rollback(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("unable to roll back batch %d: %s\n", stage.Batch().ID, err)
+		}
+	}()
+
+	batch := stage.Batch()
+	hasher := hash.NewSha256()
+
+	fileNames := make([]string, len(upload.files))
+	leafHashes := make([][]byte, len(upload.files))
+	for i, file := range upload.files {
+		fileNames[i], leafHashes[i] = file.name, file.leafHash
+	}
+
+	var uploadedFiles []types.UploadedFile
+	var treeLeafHashes hash.HashList
+
+	for _, fileIdx := range upload.ordering.Permutation(fileNames, leafHashes) {
+		file := upload.files[fileIdx]
+
+		var i int
+		i, err = stage.StoreFile(ctx, storage.StoredFile{
+			BatchID:     batch.ID,
+			Name:        file.name,
+			BlobKey:     file.blobKey,
+			Size:        file.size,
+			Mode:        file.mode,
+			ModTime:     file.modTime,
+			ContentType: file.contentType,
+		})
+		if err != nil {
+			return
+		}
+
+		uploadedFiles = append(uploadedFiles, types.UploadedFile{
+			Name:  file.name,
+			Index: i,
+			Hash:  hex.EncodeToString(file.leafHash),
+		})
+
+		treeLeafHashes = append(treeLeafHashes, file.leafHash)
+	}
+
+	merkleTree, err := merkle.NewTreeFromLeafHashes(treeLeafHashes, hasher)
+	if err != nil {
+		return
+	}
+
+	if err = stage.StoreTree(ctx, merkleTree); err != nil {
+		err = fmt.Errorf("unable to store the merkle tree: %s", err)
+
+		return
+	}
+
+	if err = stage.StoreDag(ctx, upload.dagRoot); err != nil {
+		err = fmt.Errorf("unable to store the dag: %s", err)
+
+		return
+	}
+
+	treeHead := sth.SignedTreeHead{TreeHead: sth.TreeHead{
+		BatchID:   batch.ID,
+		Root:      merkleTree.RootHex(),
+		Size:      merkleTree.Size,
+		Algorithm: hasher.Name(),
+		Timestamp: time.Now().UnixMilli(),
+		DagRoot:   batch.DagRoot,
+	}}
+	if signer != nil {
+		treeHead = signer.Sign(treeHead.TreeHead)
+	}
+
+	if err = stage.Commit(ctx, treeHead); err != nil {
+		err = fmt.Errorf("unable to commit the batch: %s", err)
+
+		return
+	}
+
+	return types.UploadedFilesResponse{
+		UploadedFiles: uploadedFiles,
+		TreeHead:      treeHead,
+		Ordering:      batch.Ordering,
+		LeafFormat:    batch.LeafFormat,
+	}, nil
+}
+
+func newBatch(ordering types.Ordering, leafFormat merkle.LeafFormat, dagRoot *dag.Node) storage.Batch {
+	batch := storage.Batch{Ordering: ordering, LeafFormat: leafFormat}
+	if dagRoot != nil {
+		batch.DagRoot = dagRoot.CID()
+	}
+
+	return batch
+}
+
+// detectContentType returns the media type of the file by its extension, or sniffed from its
+// content if the extension is unknown.
+func detectContentType(name string, content []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+
+	return http.DetectContentType(content)
+}
+
+// releaseBlobs releases the references held on the blobs, the ones no longer referenced are deleted.
+func releaseBlobs(ctx context.Context, repository storage.Repository, blobKeys []string) {
+	for _, blobKey := range blobKeys {
+		if err := repository.ReleaseBlob(ctx, blobKey); err != nil {
+			log.Printf("unable to release blob %s: %s\n", blobKey, err)
+		}
+	}
+}
+
+// fileLeafFromHeader returns the file metadata sent in the headers of the file part, the content
+// is left to be read from the part.
+func fileLeafFromHeader(fileHeader *multipart.FileHeader) (fileLeaf merkle.FileLeaf, err error) {
+	fileLeaf.Path, err = fileNameFromHeader(fileHeader)
+	if err != nil {
+		return
+	}
+
+	err = fileAttrsFromHeader(http.Header(fileHeader.Header), &fileLeaf)
+
+	return
+}
+
+// fileAttrsFromHeader sets the file mode and modification time sent in the header, if any.
+func fileAttrsFromHeader(header http.Header, fileLeaf *merkle.FileLeaf) error {
+	if mode := header.Get(types.HeaderFileMode); mode != "" {
+		parsedMode, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s header of %s: %s", types.HeaderFileMode, fileLeaf.Path, err)
+		}
+
+		fileLeaf.Mode = uint32(parsedMode)
+	}
+
+	if modTime := header.Get(types.HeaderFileModTime); modTime != "" {
+		var err error
+		if fileLeaf.ModTime, err = strconv.ParseInt(modTime, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s header of %s: %s", types.HeaderFileModTime, fileLeaf.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// fileNameFromHeader returns the normalized, slash separated relative path of an uploaded file.
+// The multipart reader strips the directories from the file name, so it's parsed from the raw header.
+func fileNameFromHeader(fileHeader *multipart.FileHeader) (string, error) {
+	_, params, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Disposition"))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse file content disposition: %s", err)
+	}
+
+	return types.NormalizeFileName(params["filename"])
 }
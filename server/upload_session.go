@@ -0,0 +1,500 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/storage"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
+)
+
+var (
+	errUploadSessionNotFound = errors.New("upload session not found")
+	errUploadSessionClosed   = errors.New("upload session is being committed")
+	errUploadSessionBusy     = errors.New("upload session is still receiving files")
+	errInvalidUploadSession  = errors.New("invalid upload session request")
+)
+
+// UploadSessions are the uploads whose files are sent one by one, possibly concurrently and
+// retried, then committed at once as a batch. The files are ordered by the positions they are sent
+// at, whatever order they arrive in. Sessions are scoped by api key and expire once left untouched
+// for the ttl, releasing the files received so far.
+type UploadSessions struct {
+	repository storage.Repository
+	signer     *sth.Signer
+	retention  storage.RetentionPolicy
+	ttl        time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+	// idempotencyKeys are the sessions opened with an idempotency key, by scoped key.
+	idempotencyKeys map[string]string
+}
+
+// uploadSession is an open upload session, or a committed one remembered until it expires.
+type uploadSession struct {
+	owner          [sha256.Size]byte
+	idempotencyKey string
+	request        types.UploadSessionRequest
+	// files are the received files by position, their blobs are held until the session ends.
+	files map[int]receivedFile
+	// pending counts the files being received, the session can't be committed meanwhile.
+	pending    int
+	committing bool
+	response   *types.UploadedFilesResponse
+	expiresAt  time.Time
+}
+
+func NewUploadSessions(
+	repository storage.Repository,
+	signer *sth.Signer,
+	retention storage.RetentionPolicy,
+	ttl time.Duration,
+) *UploadSessions {
+	return &UploadSessions{
+		repository:      repository,
+		signer:          signer,
+		retention:       retention,
+		ttl:             ttl,
+		sessions:        make(map[string]*uploadSession),
+		idempotencyKeys: make(map[string]string),
+	}
+}
+
+// NewUploadSessionsHandler opens an upload session. A session opened again with the same
+// idempotency key is the first one.
+func NewUploadSessionsHandler(sessions *UploadSessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		var request types.UploadSessionRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("unable to decode upload session request: %s", err))
+
+			return
+		}
+
+		var err error
+		if request.Ordering, err = types.ParseOrdering(string(request.Ordering)); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if request.LeafFormat, err = merkle.ParseLeafFormat(string(request.LeafFormat)); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		idempotencyKey, err := idempotencyKeyFromRequest(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		id, replayed, err := sessions.open(apiKeyHash(r), idempotencyKey, request)
+		if err != nil {
+			httpError(w, uploadSessionErrorStatus(err), err)
+
+			return
+		}
+
+		if replayed {
+			w.Header().Set(types.HeaderIdempotentReplayed, "true")
+		}
+
+		if err = httpOkJson(w, types.UploadSessionResponse{ID: id}); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// NewUploadSessionHandler aborts an upload session, releasing the files received so far.
+func NewUploadSessionHandler(sessions *UploadSessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		if err := sessions.abort(r.Context(), apiKeyHash(r), mux.Vars(r)["id"]); err != nil {
+			httpError(w, uploadSessionErrorStatus(err), err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// NewUploadSessionFileHandler receives the file at a position of an upload session, the request
+// body is the file content and its name and attributes are sent in the headers. A file sent again
+// at the same position replaces the previous one.
+func NewUploadSessionFileHandler(sessions *UploadSessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		position, err := strconv.Atoi(mux.Vars(r)["position"])
+		if err != nil || position < 1 {
+			httpError(w, http.StatusBadRequest, errors.New("{position} path param must be a number starting from 1"))
+
+			return
+		}
+
+		var fileLeaf merkle.FileLeaf
+		if fileLeaf.Path, err = types.NormalizeFileName(r.Header.Get(types.HeaderFileName)); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err = fileAttrsFromHeader(r.Header, &fileLeaf); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		file, err := sessions.receiveFile(r.Context(), apiKeyHash(r), mux.Vars(r)["id"], position, fileLeaf, r.Body)
+		if err != nil {
+			httpError(w, uploadSessionErrorStatus(err), err)
+
+			return
+		}
+
+		if err = httpOkJson(w, types.UploadedFile{
+			Name:  file.name,
+			Index: position,
+			Hash:  hex.EncodeToString(file.leafHash),
+		}); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// NewUploadSessionCommitHandler stores the files of an upload session as a new batch, then
+// expires the batches the retention policy no longer keeps. A session committed again gets the
+// response of the first commit.
+func NewUploadSessionCommitHandler(sessions *UploadSessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+
+			return
+		}
+
+		var request types.UploadSessionCommitRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("unable to decode upload session commit request: %s", err))
+
+			return
+		}
+
+		response, replayed, err := sessions.commit(r.Context(), apiKeyHash(r), mux.Vars(r)["id"], request.FileCount)
+		if err != nil {
+			httpError(w, uploadSessionErrorStatus(err), err)
+
+			return
+		}
+
+		if replayed {
+			w.Header().Set(types.HeaderIdempotentReplayed, "true")
+		} else {
+			expireBatches(context.WithoutCancel(r.Context()), sessions.repository, sessions.retention)
+		}
+
+		if err = httpOkJson(w, response); err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func uploadSessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUploadSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUploadSessionClosed), errors.Is(err, errUploadSessionBusy):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidUploadSession):
+		return http.StatusBadRequest
+	case errors.Is(err, errIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// open opens a session for the api key, or returns the session opened with the idempotency key.
+func (s *UploadSessions) open(
+	owner [sha256.Size]byte,
+	idempotencyKey string,
+	request types.UploadSessionRequest,
+) (id string, replayed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(time.Now())
+
+	if id, found := s.idempotencyKeys[idempotencyKey]; found && idempotencyKey != "" {
+		if s.sessions[id].request != request {
+			return "", false, errIdempotencyKeyReused
+		}
+
+		return id, true, nil
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", false, err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	s.sessions[id] = &uploadSession{
+		owner:          owner,
+		idempotencyKey: idempotencyKey,
+		request:        request,
+		files:          make(map[int]receivedFile),
+		expiresAt:      time.Now().Add(s.ttl),
+	}
+	if idempotencyKey != "" {
+		s.idempotencyKeys[idempotencyKey] = id
+	}
+
+	return id, false, nil
+}
+
+// session returns the session of the api key, it must be called with the lock held.
+func (s *UploadSessions) session(owner [sha256.Size]byte, id string) (*uploadSession, error) {
+	s.expire(time.Now())
+
+	session, found := s.sessions[id]
+	if !found || session.owner != owner {
+		return nil, fmt.Errorf("%w: %s", errUploadSessionNotFound, id)
+	}
+
+	session.expiresAt = time.Now().Add(s.ttl)
+
+	return session, nil
+}
+
+// receiveFile stores the file content read from the body at the position of the session, in place
+// of the file previously received there if any.
+func (s *UploadSessions) receiveFile(
+	ctx context.Context,
+	owner [sha256.Size]byte,
+	id string,
+	position int,
+	fileLeaf merkle.FileLeaf,
+	body io.Reader,
+) (file receivedFile, err error) {
+	s.mu.Lock()
+	session, err := s.session(owner, id)
+	if err == nil && (session.committing || session.response != nil) {
+		err = errUploadSessionClosed
+	}
+	if err != nil {
+		s.mu.Unlock()
+
+		return
+	}
+
+	session.pending++
+	request := session.request
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		session.pending--
+		previous, replaced := session.files[position]
+		if err == nil {
+			session.files[position] = file
+		}
+		s.mu.Unlock()
+
+		if err == nil && replaced {
+			releaseBlobs(context.WithoutCancel(ctx), s.repository, []string{previous.blobKey})
+		}
+	}()
+
+	// the content is streamed to the storage and digested on the way.
+	digest := newContentDigest(request.Dag)
+	reader := &bodyReader{Reader: body}
+	if file.blobKey, err = s.repository.StoreBlob(ctx, io.TeeReader(reader, digest)); err != nil {
+		if reader.err != nil {
+			err = fmt.Errorf("%w: unable to read file: %s", errInvalidUploadSession, reader.err)
+		} else {
+			err = fmt.Errorf("unable to store the file content: %s", err)
+		}
+
+		return
+	}
+
+	blobKey := file.blobKey
+	file = digest.receivedFile(fileLeaf, request.LeafFormat)
+	file.blobKey = blobKey
+
+	return
+}
+
+// commit stores the files of the session, received at the positions from 1 to the file count, as
+// a new batch. The session stays open if the commit fails.
+func (s *UploadSessions) commit(
+	ctx context.Context,
+	owner [sha256.Size]byte,
+	id string,
+	fileCount int,
+) (response types.UploadedFilesResponse, replayed bool, err error) {
+	s.mu.Lock()
+	session, err := s.session(owner, id)
+	switch {
+	case err != nil:
+	case session.response != nil:
+		response, replayed = *session.response, true
+	case session.committing:
+		err = errUploadSessionClosed
+	case session.pending > 0:
+		err = errUploadSessionBusy
+	case fileCount < 1:
+		err = fmt.Errorf("%w: %w", errInvalidUploadSession, errNoFiles)
+	case len(session.files) != fileCount:
+		err = fmt.Errorf(
+			"%w: %d files were received, expected %d", errInvalidUploadSession, len(session.files), fileCount,
+		)
+	default:
+		session.committing = true
+	}
+	if err != nil || replayed {
+		s.mu.Unlock()
+
+		return
+	}
+
+	upload := receivedUpload{
+		ordering:   session.request.Ordering,
+		leafFormat: session.request.LeafFormat,
+		withDag:    session.request.Dag,
+		files:      make([]receivedFile, fileCount),
+	}
+	for position := 1; position <= fileCount; position++ {
+		file, found := session.files[position]
+		if !found {
+			session.committing = false
+			s.mu.Unlock()
+
+			err = fmt.Errorf("%w: no file was received at position %d", errInvalidUploadSession, position)
+
+			return
+		}
+
+		upload.files[position-1] = file
+	}
+	s.mu.Unlock()
+
+	if upload.dagRoot, err = buildDag(upload); err != nil {
+		err = fmt.Errorf("%w: %s", errInvalidUploadSession, err)
+	} else {
+		response, err = commitUpload(ctx, s.repository, s.signer, upload)
+	}
+
+	s.mu.Lock()
+	session.committing = false
+	if err == nil {
+		// the committed files hold their own references to the blobs.
+		session.response = &response
+		session.files = nil
+	}
+	s.mu.Unlock()
+
+	if err == nil {
+		releaseBlobs(context.WithoutCancel(ctx), s.repository, blobKeys(upload.files))
+	}
+
+	return
+}
+
+// abort ends the session, releasing the files received so far.
+func (s *UploadSessions) abort(ctx context.Context, owner [sha256.Size]byte, id string) error {
+	s.mu.Lock()
+	session, err := s.session(owner, id)
+	if err == nil && (session.committing || session.pending > 0) {
+		err = errUploadSessionBusy
+	}
+	if err != nil {
+		s.mu.Unlock()
+
+		return err
+	}
+
+	s.remove(id, session)
+	s.mu.Unlock()
+
+	releaseBlobs(context.WithoutCancel(ctx), s.repository, sessionBlobKeys(session))
+
+	return nil
+}
+
+// expire ends the sessions left untouched for the ttl, the ones receiving or committing files are
+// kept. It must be called with the lock held, the blobs are released in the background.
+func (s *UploadSessions) expire(now time.Time) {
+	var expiredBlobKeys []string
+	for id, session := range s.sessions {
+		if session.pending == 0 && !session.committing && now.After(session.expiresAt) {
+			s.remove(id, session)
+			expiredBlobKeys = append(expiredBlobKeys, sessionBlobKeys(session)...)
+		}
+	}
+
+	if len(expiredBlobKeys) > 0 {
+		go releaseBlobs(context.Background(), s.repository, expiredBlobKeys)
+	}
+}
+
+// remove forgets the session, it must be called with the lock held.
+func (s *UploadSessions) remove(id string, session *uploadSession) {
+	delete(s.sessions, id)
+	if session.idempotencyKey != "" {
+		delete(s.idempotencyKeys, session.idempotencyKey)
+	}
+}
+
+func sessionBlobKeys(session *uploadSession) []string {
+	keys := make([]string, 0, len(session.files))
+	for _, file := range session.files {
+		keys = append(keys, file.blobKey)
+	}
+
+	return keys
+}
+
+func blobKeys(files []receivedFile) []string {
+	keys := make([]string, len(files))
+	for i, file := range files {
+		keys[i] = file.blobKey
+	}
+
+	return keys
+}
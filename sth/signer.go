@@ -0,0 +1,57 @@
+package sth
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Signer signs tree heads with an Ed25519 private key.
+type Signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+// NewSigner creates a new signer out of a 32 bytes Ed25519 seed.
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ed25519 seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+
+	return &Signer{privateKey: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// NewSignerFromHex creates a new signer out of a hexadecimal Ed25519 seed.
+func NewSignerFromHex(hexSeed string) (*Signer, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(hexSeed))
+	if err != nil {
+		return nil, fmt.Errorf("ed25519 seed is not hexadecimal: %s", err)
+	}
+
+	return NewSigner(seed)
+}
+
+// NewSignerFromFile creates a new signer out of a file containing a hexadecimal Ed25519 seed.
+func NewSignerFromFile(filename string) (*Signer, error) {
+	hexSeed, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSignerFromHex(string(hexSeed))
+}
+
+// PublicKey returns the public key of the signer.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// Sign signs the tree head.
+func (s *Signer) Sign(head TreeHead) SignedTreeHead {
+	return SignedTreeHead{
+		TreeHead:  head,
+		Signature: hex.EncodeToString(ed25519.Sign(s.privateKey, head.Message())),
+		PublicKey: hex.EncodeToString(s.PublicKey()),
+	}
+}
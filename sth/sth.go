@@ -0,0 +1,100 @@
+package sth
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrMissingSignature = errors.New("tree head is not signed")
+	ErrInvalidSignature = errors.New("tree head signature is invalid")
+)
+
+// SignatureAlgorithm is the name of the tree head signature algorithm.
+const SignatureAlgorithm = "ed25519"
+
+// messagePrefix separates the signed tree head messages from any other signed payload.
+const messagePrefix = "fxmerkle-tree-head/v1"
+
+// TreeHead describes the merkle tree the server committed to.
+type TreeHead struct {
+	// BatchID is the identifier of the uploaded batch of files the tree commits to.
+	BatchID int `json:"batchId"`
+	// Root is the hexadecimal merkle root hash.
+	Root string `json:"root"`
+	// Size is the number of leaves of the tree.
+	Size int `json:"size"`
+	// Algorithm is the name of the hashing algorithm used to build the tree.
+	Algorithm string `json:"algorithm"`
+	// Timestamp is the unix time in milliseconds at which the tree was committed.
+	Timestamp int64 `json:"timestamp"`
+	// DagRoot is the content identifier of the directory structured dag of the files, if built.
+	DagRoot string `json:"dagRoot,omitempty"`
+}
+
+// Message returns the canonical bytes of the tree head which are signed by the server.
+func (h TreeHead) Message() []byte {
+	return []byte(fmt.Sprintf(
+		"%s\n%d\n%s\n%d\n%s\n%d\n%s\n",
+		messagePrefix, h.BatchID, h.Root, h.Size, h.Algorithm, h.Timestamp, h.DagRoot,
+	))
+}
+
+// SignedTreeHead is a tree head along with the optional Ed25519 signature of the server.
+type SignedTreeHead struct {
+	TreeHead
+	// Signature is the hexadecimal Ed25519 signature of the tree head message.
+	Signature string `json:"signature,omitempty"`
+	// PublicKey is the hexadecimal Ed25519 public key of the signer.
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// IsSigned returns true if the tree head carries a signature.
+func (h SignedTreeHead) IsSigned() bool {
+	return h.Signature != ""
+}
+
+// Verify checks the signature of the tree head against the public key.
+func (h SignedTreeHead) Verify(publicKey ed25519.PublicKey) error {
+	if !h.IsSigned() {
+		return ErrMissingSignature
+	}
+
+	signature, err := hex.DecodeString(h.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, h.Message(), signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// VerifyEmbedded checks the signature of the tree head against the public key it carries.
+func (h SignedTreeHead) VerifyEmbedded() error {
+	publicKey, err := ParsePublicKey(h.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return h.Verify(publicKey)
+}
+
+// ParsePublicKey parses a hexadecimal Ed25519 public key.
+func ParsePublicKey(hexKey string) (ed25519.PublicKey, error) {
+	publicKey, err := hex.DecodeString(strings.TrimSpace(hexKey))
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %s", err)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(publicKey))
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,13 @@
+package storage
+
+import (
+	"encoding/hex"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// BlobKey returns the key a content is stored under, its hexadecimal hash, which is the leaf hash
+// of the file in the content leaf format.
+func BlobKey(content []byte) string {
+	return hex.EncodeToString(hash.NewSha256().Hash(content))
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+)
+
+// dagRecord is a dag node flattened under its slash separated path, the root path is empty.
+type dagRecord struct {
+	Path string    `json:"path"`
+	Kind dag.Kind  `json:"kind"`
+	Hash hash.Hash `json:"hash"`
+	Size int64     `json:"size"`
+}
+
+// flattenDag returns the records of the dag nodes, parents before their children.
+func flattenDag(root *dag.Node) []dagRecord {
+	if root == nil {
+		return nil
+	}
+
+	records := []dagRecord{{Kind: root.Kind, Hash: root.Hash, Size: root.Size}}
+	for _, child := range root.Children {
+		for _, record := range flattenDag(child) {
+			record.Path = strings.TrimSuffix(child.Name+"/"+record.Path, "/")
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// unflattenDag rebuilds the dag out of its node records, nil if there is none.
+func unflattenDag(records []dagRecord) (*dag.Node, error) {
+	// parents sort before their children, and siblings by name, as their path is a prefix.
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+
+	nodes := make(map[string]*dag.Node, len(records))
+	var root *dag.Node
+	for _, record := range records {
+		node := &dag.Node{Entry: dag.Entry{Kind: record.Kind, Hash: record.Hash, Size: record.Size}}
+		nodes[record.Path] = node
+		if record.Path == "" {
+			root = node
+
+			continue
+		}
+
+		parentPath := ""
+		node.Name = record.Path
+		if slash := strings.LastIndex(record.Path, "/"); slash >= 0 {
+			parentPath, node.Name = record.Path[:slash], record.Path[slash+1:]
+		}
+
+		parent, found := nodes[parentPath]
+		if !found {
+			return nil, fmt.Errorf("parent of dag node %s is not stored", record.Path)
+		}
+
+		parent.Children = append(parent.Children, node)
+	}
+
+	return root, nil
+}
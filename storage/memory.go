@@ -3,65 +3,318 @@ package storage
 import (
 	"context"
 	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"io"
+	"sort"
 	"sync"
+	"time"
 )
 
 var _ Repository = (*InMemoryStorage)(nil)
 
 type InMemoryStorage struct {
-	mu    sync.RWMutex
-	seq   int
-	files map[int]StoredFile
-	tree  *merkle.Tree
+	mu       sync.RWMutex
+	blobs    map[string]*blob
+	batchSeq int
+	batches  map[int]*inMemoryBatch
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		files: make(map[int]StoredFile),
+		blobs:   make(map[string]*blob),
+		batches: make(map[int]*inMemoryBatch),
 	}
 }
 
-func (s *InMemoryStorage) StoreFile(_ context.Context, file StoredFile) (int, error) {
+// blob is a content stored once along with the count of its references.
+type blob struct {
+	content []byte
+	refs    int
+}
+
+// inMemoryBatch is a committed batch, its files are in index order.
+type inMemoryBatch struct {
+	batch Batch
+	files []StoredFile
+	tree  *merkle.Tree
+	dag   *dag.Node
+	head  sth.SignedTreeHead
+}
+
+func (s *InMemoryStorage) StoreBlob(_ context.Context, reader io.Reader) (string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.seq++
-	file.Index = s.seq
-	s.files[s.seq] = file
+	key := BlobKey(content)
+	if stored, found := s.blobs[key]; found {
+		stored.refs++
 
-	return s.seq, nil
+		return key, nil
+	}
+
+	s.blobs[key] = &blob{content: content, refs: 1}
+
+	return key, nil
 }
 
-func (s *InMemoryStorage) RetrieveFileByIndex(_ context.Context, i int) (storedFile StoredFile, err error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *InMemoryStorage) RetrieveBlob(ctx context.Context, key string) ([]byte, error) {
+	return viewValue(ctx, s, func(snapshot Snapshot) ([]byte, error) { return snapshot.RetrieveBlob(key) })
+}
 
-	storedFile, found := s.files[i]
+func (s *InMemoryStorage) ReleaseBlob(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.releaseBlob(key)
+}
+
+// releaseBlob drops a reference to the blob and deletes it once it is no longer referenced.
+func (s *InMemoryStorage) releaseBlob(key string) error {
+	stored, found := s.blobs[key]
 	if !found {
-		err = ErrStoredFileNotFound
+		return ErrBlobNotFound
 	}
 
-	return
+	stored.refs--
+	if stored.refs == 0 {
+		delete(s.blobs, key)
+	}
+
+	return nil
 }
 
-func (s *InMemoryStorage) DeleteAllFiles(_ context.Context) (err error) {
+func (s *InMemoryStorage) StageBatch(_ context.Context, batch Batch) (BatchStage, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.seq = 0
-	s.files = make(map[int]StoredFile)
+	s.batchSeq++
+	batch.ID = s.batchSeq
+	batch.CreatedAt = time.Now()
+
+	return &inMemoryStage{storage: s, batch: batch}, nil
+}
+
+func (s *InMemoryStorage) DeleteBatch(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, found := s.batches[id]
+	if !found {
+		return ErrBatchNotFound
+	}
+
+	for _, file := range stored.files {
+		if err := s.releaseBlob(file.BlobKey); err != nil {
+			return err
+		}
+	}
+
+	delete(s.batches, id)
 
 	return nil
 }
 
-func (s *InMemoryStorage) StoreTree(_ context.Context, tree *merkle.Tree) error {
+// View holds the read lock while the function runs, commits wait for it to return.
+func (s *InMemoryStorage) View(_ context.Context, fn func(Snapshot) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(inMemorySnapshot{storage: s})
+}
+
+// inMemorySnapshot reads the storage while its read lock is held by the View call.
+type inMemorySnapshot struct {
+	storage *InMemoryStorage
+}
+
+func (sn inMemorySnapshot) Version() (version int) {
+	for id := range sn.storage.batches {
+		version = max(version, id)
+	}
+
+	return
+}
+
+func (sn inMemorySnapshot) RetrieveBlob(key string) ([]byte, error) {
+	stored, found := sn.storage.blobs[key]
+	if !found {
+		return nil, ErrBlobNotFound
+	}
+
+	return stored.content, nil
+}
+
+func (sn inMemorySnapshot) RetrieveBatches() ([]Batch, error) {
+	batches := make([]Batch, 0, len(sn.storage.batches))
+	for _, stored := range sn.storage.batches {
+		batches = append(batches, stored.batch)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID < batches[j].ID })
+
+	return batches, nil
+}
+
+func (sn inMemorySnapshot) RetrieveBatch(id int) (Batch, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return Batch{}, err
+	}
+
+	return stored.batch, nil
+}
+
+func (sn inMemorySnapshot) RetrieveFileByIndex(batchID, i int) (StoredFile, error) {
+	stored, found := sn.storage.batches[batchID]
+	if !found || i < 1 || i > len(stored.files) {
+		return StoredFile{}, ErrStoredFileNotFound
+	}
+
+	return stored.files[i-1], nil
+}
+
+func (sn inMemorySnapshot) RetrieveBatchFiles(id int) ([]StoredFile, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.files, nil
+}
+
+func (sn inMemorySnapshot) RetrieveFiles(batchID, fromIndex, limit int) ([]StoredFile, error) {
+	stored, err := sn.batch(batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := stored.files[min(max(fromIndex, 1)-1, len(stored.files)):]
+
+	return files[:min(limit, len(files))], nil
+}
+
+func (sn inMemorySnapshot) RetrieveTree(id int) (*merkle.Tree, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.tree, nil
+}
+
+func (sn inMemorySnapshot) RetrieveDag(id int) (*dag.Node, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.dag, nil
+}
+
+func (sn inMemorySnapshot) RetrieveTreeHead(id int) (sth.SignedTreeHead, error) {
+	stored, found := sn.storage.batches[id]
+	if !found {
+		return sth.SignedTreeHead{}, ErrTreeHeadNotFound
+	}
+
+	return stored.head, nil
+}
+
+func (sn inMemorySnapshot) batch(id int) (*inMemoryBatch, error) {
+	stored, found := sn.storage.batches[id]
+	if !found {
+		return nil, ErrBatchNotFound
+	}
+
+	return stored, nil
+}
+
+// inMemoryStage keeps the staged batch aside until it is added by the commit.
+type inMemoryStage struct {
+	storage *InMemoryStorage
+	batch   Batch
+	files   []StoredFile
+	tree    *merkle.Tree
+	dag     *dag.Node
+	done    bool
+}
+
+func (st *inMemoryStage) Batch() Batch {
+	return st.batch
+}
+
+func (st *inMemoryStage) StoreFile(_ context.Context, file StoredFile) (int, error) {
+	if st.done {
+		return 0, ErrStageDone
+	}
+
+	file.BatchID = st.batch.ID
+	file.Index = len(st.files) + 1
+	st.files = append(st.files, file)
+
+	return file.Index, nil
+}
+
+func (st *inMemoryStage) StoreTree(_ context.Context, tree *merkle.Tree) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	st.tree = tree
+
+	return nil
+}
+
+func (st *inMemoryStage) StoreDag(_ context.Context, root *dag.Node) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	st.dag = root
+
+	return nil
+}
+
+func (st *inMemoryStage) Commit(_ context.Context, head sth.SignedTreeHead) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	s := st.storage
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.tree = tree
+	for _, file := range st.files {
+		if _, found := s.blobs[file.BlobKey]; !found {
+			return ErrBlobNotFound
+		}
+	}
+
+	for _, file := range st.files {
+		s.blobs[file.BlobKey].refs++
+		st.batch.Size += file.Size
+	}
+	st.batch.FileCount = len(st.files)
+
+	s.batches[st.batch.ID] = &inMemoryBatch{
+		batch: st.batch,
+		files: st.files,
+		tree:  st.tree,
+		dag:   st.dag,
+		head:  head,
+	}
+	st.done = true
+
 	return nil
 }
 
-func (s *InMemoryStorage) RetrieveTree(_ context.Context) (*merkle.Tree, error) {
-	return s.tree, nil
+func (st *inMemoryStage) Rollback(_ context.Context) error {
+	st.done = true
+
+	return nil
 }
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// testRepositories returns a repository of every storage, the s3 one only if a test server is set.
+func testRepositories(t *testing.T) map[string]func(t *testing.T) Repository {
+	return map[string]func(t *testing.T) Repository{
+		"memory": func(*testing.T) Repository {
+			return NewInMemoryStorage()
+		},
+		"sqlite": func(t *testing.T) Repository {
+			return newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "fxmerkle.db"))
+		},
+		"s3": func(t *testing.T) Repository {
+			return newTestS3Storage(t, newTestS3Config(t))
+		},
+	}
+}
+
+// uploadTestBatch stores the contents as the files of a new batch, the leaves of its tree are the
+// contents as in the content leaf format.
+func uploadTestBatch(ctx context.Context, repository Repository, contents [][]byte) (batchID int, err error) {
+	blobKeys := make([]string, len(contents))
+	for i, content := range contents {
+		if blobKeys[i], err = repository.StoreBlob(ctx, bytes.NewReader(content)); err != nil {
+			return
+		}
+	}
+	defer func() {
+		for _, key := range blobKeys {
+			err = errors.Join(err, repository.ReleaseBlob(ctx, key))
+		}
+	}()
+
+	stage, err := repository.StageBatch(ctx, Batch{})
+	if err != nil {
+		return
+	}
+	defer func() { err = errors.Join(err, stage.Rollback(ctx)) }()
+
+	hasher := hash.NewSha256()
+	leafHashes := make(hash.HashList, len(contents))
+	for i, content := range contents {
+		if _, err = stage.StoreFile(ctx, StoredFile{Name: fmt.Sprint(i), BlobKey: blobKeys[i], Size: int64(len(content))}); err != nil {
+			return
+		}
+
+		leafHashes[i] = hasher.Hash(content)
+	}
+
+	tree, err := merkle.NewTreeFromLeafHashes(leafHashes, hasher)
+	if err != nil {
+		return
+	}
+
+	if err = stage.StoreTree(ctx, tree); err != nil {
+		return
+	}
+
+	batchID = stage.Batch().ID
+	err = stage.Commit(ctx, sth.SignedTreeHead{TreeHead: sth.TreeHead{BatchID: batchID, Root: tree.RootHex(), Size: tree.Size}})
+
+	return
+}
+
+// checkSnapshot checks the last batch of the snapshot is whole: its tree head, tree, files and
+// blobs all belong together.
+func checkSnapshot(snapshot Snapshot) error {
+	version := snapshot.Version()
+	if version == 0 {
+		return nil
+	}
+
+	head, err := snapshot.RetrieveTreeHead(version)
+	if err != nil {
+		return fmt.Errorf("tree head of batch %d: %w", version, err)
+	}
+
+	tree, err := snapshot.RetrieveTree(version)
+	if err != nil || tree == nil {
+		return fmt.Errorf("tree of batch %d: %v", version, err)
+	}
+
+	if head.BatchID != version || head.Root != tree.RootHex() {
+		return fmt.Errorf("batch %d has the tree head of batch %d", version, head.BatchID)
+	}
+
+	files, err := snapshot.RetrieveBatchFiles(version)
+	if err != nil {
+		return fmt.Errorf("files of batch %d: %w", version, err)
+	}
+
+	if len(files) != tree.Size {
+		return fmt.Errorf("batch %d has %d files for %d leaves", version, len(files), tree.Size)
+	}
+
+	hasher := hash.NewSha256()
+	for i, file := range files {
+		content, err := snapshot.RetrieveBlob(file.BlobKey)
+		if err != nil {
+			return fmt.Errorf("blob of file %d of batch %d: %w", file.Index, version, err)
+		}
+
+		leafHash, err := tree.LeafHash(uint64(i))
+		if err != nil || !bytes.Equal(hasher.Hash(content), leafHash) {
+			return fmt.Errorf("file %d of batch %d does not match its leaf", file.Index, version)
+		}
+	}
+
+	return nil
+}
+
+// TestRepositoryConcurrentUploadsDeletesAndReads uploads and deletes batches sharing blobs while
+// snapshots are read, every snapshot must see whole batches. Run it with -race.
+func TestRepositoryConcurrentUploadsDeletesAndReads(t *testing.T) {
+	const (
+		uploaders        = 4
+		uploadsPerWorker = 10
+		readers          = 4
+		keptBatches      = 2
+	)
+
+	for name, newRepository := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			repository := newRepository(t)
+			ctx := context.Background()
+			shared := []byte("shared by every batch")
+
+			var uploads sync.WaitGroup
+			errs := make(chan error, uploaders*uploadsPerWorker+readers+1)
+			for worker := 0; worker < uploaders; worker++ {
+				uploads.Add(1)
+				go func() {
+					defer uploads.Done()
+
+					for upload := 0; upload < uploadsPerWorker; upload++ {
+						contents := [][]byte{shared, []byte(fmt.Sprintf("upload %d of worker %d", upload, worker))}
+						if _, err := uploadTestBatch(ctx, repository, contents); err != nil {
+							errs <- fmt.Errorf("upload %d of worker %d: %w", upload, worker, err)
+						}
+					}
+				}()
+			}
+
+			done := make(chan struct{})
+			var workers sync.WaitGroup
+			for reader := 0; reader < readers; reader++ {
+				workers.Add(1)
+				go func() {
+					defer workers.Done()
+
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+
+						if err := repository.View(ctx, checkSnapshot); err != nil {
+							errs <- err
+
+							return
+						}
+					}
+				}()
+			}
+
+			// the oldest batches are deleted as the new ones are committed.
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					batches, err := viewValue(ctx, repository, func(snapshot Snapshot) ([]Batch, error) {
+						return snapshot.RetrieveBatches()
+					})
+					if err != nil {
+						errs <- err
+
+						return
+					}
+
+					if len(batches) > keptBatches {
+						if err = repository.DeleteBatch(ctx, batches[0].ID); err != nil {
+							errs <- fmt.Errorf("delete batch %d: %w", batches[0].ID, err)
+
+							return
+						}
+					}
+				}
+			}()
+
+			uploads.Wait()
+			close(done)
+			workers.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// once every batch is deleted, no blob is left.
+			batches, err := viewValue(ctx, repository, func(snapshot Snapshot) ([]Batch, error) {
+				return snapshot.RetrieveBatches()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, batch := range batches {
+				if err = repository.DeleteBatch(ctx, batch.ID); err != nil {
+					t.Fatalf("unable to delete batch %d: %s", batch.ID, err)
+				}
+			}
+
+			if _, err = repository.RetrieveBlob(ctx, BlobKey(shared)); !errors.Is(err, ErrBlobNotFound) {
+				t.Fatalf("the blob shared by the deleted batches is retrieved: %v", err)
+			}
+		})
+	}
+}
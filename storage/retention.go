@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy expires the committed batches older than the TTL, then the oldest batches as long
+// as the total size of the batches is over the maximum size. The last batch is always kept, so the
+// current tree head can still be served. A zero TTL or maximum size disables the limit.
+type RetentionPolicy struct {
+	TTL     time.Duration
+	MaxSize int64
+}
+
+// Enabled tells whether the policy expires any batch.
+func (p RetentionPolicy) Enabled() bool {
+	return p.TTL > 0 || p.MaxSize > 0
+}
+
+// Expired returns the ids of the batches, oldest first, the policy expires at the time.
+func (p RetentionPolicy) Expired(batches []Batch, now time.Time) (expired []int) {
+	if len(batches) == 0 {
+		return nil
+	}
+
+	var totalSize int64
+	for _, batch := range batches {
+		totalSize += batch.Size
+	}
+
+	for _, batch := range batches[:len(batches)-1] {
+		tooOld := p.TTL > 0 && now.Sub(batch.CreatedAt) > p.TTL
+		tooLarge := p.MaxSize > 0 && totalSize > p.MaxSize
+		if !tooOld && !tooLarge {
+			break
+		}
+
+		expired = append(expired, batch.ID)
+		totalSize -= batch.Size
+	}
+
+	return
+}
+
+// Expire deletes the batches the policy expires at the time and returns their ids.
+func (p RetentionPolicy) Expire(ctx context.Context, repository Repository, now time.Time) (expired []int, err error) {
+	if !p.Enabled() {
+		return nil, nil
+	}
+
+	err = repository.View(ctx, func(snapshot Snapshot) error {
+		batches, err := snapshot.RetrieveBatches()
+		expired = p.Expired(batches, now)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the batches: %s", err)
+	}
+
+	for i, batchID := range expired {
+		// a batch may be deleted meanwhile.
+		if err = repository.DeleteBatch(ctx, batchID); err != nil && !errors.Is(err, ErrBatchNotFound) {
+			return expired[:i], fmt.Errorf("unable to delete batch %d: %s", batchID, err)
+		}
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,600 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+var _ Repository = (*S3Storage)(nil)
+
+const (
+	s3SequenceObject = "sequence.json"
+	s3BatchObject    = "batch.json"
+	s3TreeObject     = "tree.json"
+	s3DagObject      = "dag.json"
+	s3BatchesPrefix  = "batches"
+	s3BlobsPrefix    = "blobs"
+	s3UploadsPrefix  = "uploads"
+	defaultPartSize  = 16 << 20
+	// maxCopyObjectSize is the size of the largest object copied by a single request.
+	maxCopyObjectSize = 5 << 30
+)
+
+// S3Config is the configuration of the bucket of an S3 compatible object storage.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Secure    bool
+	Bucket    string
+	// Prefix is the key prefix of every object stored in the bucket.
+	Prefix string
+	// PartSize is the size of the parts blobs are uploaded in, 16 MiB if zero.
+	PartSize uint64
+}
+
+// S3Storage stores the blobs as objects of an S3 compatible bucket, along with an object per
+// committed batch for its metadata and files, and objects for its tree and dag, written once per
+// batch and read when needed. Writing the batch object commits the batch.
+//
+// The batches metadata is kept in memory. The blob references are only kept in memory: they are
+// held by the files of the stored batches, counted again when the storage is loaded, and by the
+// uploads in progress, which don't survive a restart.
+//
+// The lock is only held to update the batches and blob references, never while objects are
+// written: a blob is copied or deleted while marked pending, an upload of the same blob waits for
+// it, and a batch takes its blob references before its batch object is written.
+type S3Storage struct {
+	client *minio.Client
+	config S3Config
+
+	// seqMu orders the writes of the batch sequence.
+	seqMu    sync.Mutex
+	batchSeq int
+
+	mu       sync.RWMutex
+	batches  map[int]*s3Batch
+	blobRefs map[string]int
+	// blobsPending are the blobs being copied or deleted, their channel is closed once done.
+	blobsPending map[string]chan struct{}
+}
+
+// s3Sequence is the last batch id handed out, as written to the sequence object.
+type s3Sequence struct {
+	BatchSeq int `json:"batchSeq"`
+}
+
+// s3Batch is a committed batch as written to its batch object, its files are in index order.
+type s3Batch struct {
+	Batch Batch              `json:"batch"`
+	Files []StoredFile       `json:"files"`
+	Head  sth.SignedTreeHead `json:"head"`
+}
+
+// NewS3Storage connects to the bucket, creating it if needed, and loads the stored batches.
+func NewS3Storage(ctx context.Context, config S3Config) (*S3Storage, error) {
+	if config.PartSize == 0 {
+		config.PartSize = defaultPartSize
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.Secure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the s3 client for %s: %s", config.Endpoint, err)
+	}
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check the s3 bucket %s: %s", config.Bucket, err)
+	}
+
+	if !exists {
+		if err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			return nil, fmt.Errorf("unable to create the s3 bucket %s: %s", config.Bucket, err)
+		}
+	}
+
+	s := &S3Storage{client: client, config: config}
+	if err = s.load(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load the s3 storage: %s", err)
+	}
+
+	return s, nil
+}
+
+// load reads the batch sequence and the batch objects, counts the blob references of their files,
+// then deletes the blobs and uploads left behind by the uploads interrupted by a restart.
+func (s *S3Storage) load(ctx context.Context) error {
+	var sequence s3Sequence
+	if err := s.getJsonObject(ctx, s.objectKey(s3SequenceObject), &sequence); err != nil {
+		return err
+	}
+
+	s.batchSeq = sequence.BatchSeq
+	s.batches = make(map[int]*s3Batch)
+	s.blobRefs = make(map[string]int)
+	s.blobsPending = make(map[string]chan struct{})
+
+	batchObjects := s.client.ListObjects(ctx, s.config.Bucket, minio.ListObjectsOptions{
+		Prefix:    s.objectKey(s3BatchesPrefix) + "/",
+		Recursive: true,
+	})
+	for object := range batchObjects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		if path.Base(object.Key) != s3BatchObject {
+			continue
+		}
+
+		stored := &s3Batch{}
+		if err := s.getJsonObject(ctx, object.Key, stored); err != nil {
+			return fmt.Errorf("unable to read %s: %s", object.Key, err)
+		}
+
+		s.batches[stored.Batch.ID] = stored
+		s.batchSeq = max(s.batchSeq, stored.Batch.ID)
+		for _, file := range stored.Files {
+			s.blobRefs[file.BlobKey]++
+		}
+	}
+
+	for _, prefix := range []string{s3BlobsPrefix, s3UploadsPrefix} {
+		objects := s.client.ListObjects(ctx, s.config.Bucket, minio.ListObjectsOptions{
+			Prefix:    s.objectKey(prefix) + "/",
+			Recursive: true,
+		})
+		for object := range objects {
+			if object.Err != nil {
+				return object.Err
+			}
+
+			if prefix == s3BlobsPrefix && s.blobRefs[path.Base(object.Key)] > 0 {
+				continue
+			}
+
+			if err := s.client.RemoveObject(ctx, s.config.Bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+				return fmt.Errorf("unable to delete %s: %s", object.Key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// StoreBlob streams the content to an upload object while hashing it, then copies it to the
+// object of its key, unless already stored.
+func (s *S3Storage) StoreBlob(ctx context.Context, content io.Reader) (string, error) {
+	uploadKey := s.objectKey(path.Join(s3UploadsPrefix, newObjectID()))
+	contentHash := hash.NewSha256().New()
+	counter := &byteCounter{}
+	err := s.putObject(ctx, uploadKey, io.TeeReader(content, io.MultiWriter(contentHash, counter)), -1, "application/octet-stream")
+	if err != nil {
+		return "", fmt.Errorf("unable to upload the blob: %s", err)
+	}
+	defer func() {
+		err := s.client.RemoveObject(context.WithoutCancel(ctx), s.config.Bucket, uploadKey, minio.RemoveObjectOptions{})
+		if err != nil {
+			log.Printf("unable to delete %s: %s\n", uploadKey, err)
+		}
+	}()
+
+	key := hex.EncodeToString(contentHash.Sum(nil))
+	for {
+		s.mu.Lock()
+		if s.blobRefs[key] > 0 {
+			s.blobRefs[key]++
+			s.mu.Unlock()
+
+			return key, nil
+		}
+
+		// the blob is being copied by another upload or deleted, it is looked up again once done.
+		if pending, found := s.blobsPending[key]; found {
+			s.mu.Unlock()
+
+			select {
+			case <-pending:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		done := make(chan struct{})
+		s.blobsPending[key] = done
+		s.mu.Unlock()
+
+		err = s.copyObject(ctx, uploadKey, s.blobKey(key), counter.n)
+
+		s.mu.Lock()
+		delete(s.blobsPending, key)
+		close(done)
+		if err == nil {
+			s.blobRefs[key]++
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return "", fmt.Errorf("unable to store blob %s: %s", key, err)
+		}
+
+		return key, nil
+	}
+}
+
+func (s *S3Storage) RetrieveBlob(ctx context.Context, key string) ([]byte, error) {
+	return viewValue(ctx, s, func(snapshot Snapshot) ([]byte, error) { return snapshot.RetrieveBlob(key) })
+}
+
+func (s *S3Storage) ReleaseBlob(ctx context.Context, key string) error {
+	s.mu.Lock()
+	unreferenced, err := s.releaseBlob(key)
+	s.mu.Unlock()
+	if err != nil || !unreferenced {
+		return err
+	}
+
+	return s.removeBlobs(ctx, key)
+}
+
+// releaseBlob drops a reference to the blob and reports whether it is no longer referenced, then
+// it is marked pending until the caller removes it. The lock must be held.
+func (s *S3Storage) releaseBlob(key string) (unreferenced bool, err error) {
+	if s.blobRefs[key] == 0 {
+		return false, ErrBlobNotFound
+	}
+
+	s.blobRefs[key]--
+	if s.blobRefs[key] > 0 {
+		return false, nil
+	}
+
+	delete(s.blobRefs, key)
+	s.blobsPending[key] = make(chan struct{})
+
+	return true, nil
+}
+
+// releaseBlobs drops a reference to each of the blobs, then deletes the blobs no longer referenced.
+func (s *S3Storage) releaseBlobs(ctx context.Context, keys ...string) error {
+	var unreferencedKeys []string
+	s.mu.Lock()
+	for _, key := range keys {
+		if unreferenced, _ := s.releaseBlob(key); unreferenced {
+			unreferencedKeys = append(unreferencedKeys, key)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.removeBlobs(ctx, unreferencedKeys...)
+}
+
+// removeBlobs deletes the objects of the released blobs, then lets the uploads waiting for them
+// store them again. A blob object left behind is deleted when the storage is loaded again.
+func (s *S3Storage) removeBlobs(ctx context.Context, keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := s.client.RemoveObject(ctx, s.config.Bucket, s.blobKey(key), minio.RemoveObjectOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete blob %s: %s", key, err))
+		}
+
+		s.mu.Lock()
+		close(s.blobsPending[key])
+		delete(s.blobsPending, key)
+		s.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// StageBatch reserves the batch id by writing the batch sequence before the id is handed out, so
+// no id is given twice, even after a failed write or a restart.
+func (s *S3Storage) StageBatch(ctx context.Context, batch Batch) (BatchStage, error) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+
+	if err := s.putJsonObject(ctx, s.objectKey(s3SequenceObject), s3Sequence{BatchSeq: s.batchSeq + 1}); err != nil {
+		return nil, fmt.Errorf("unable to reserve a batch id: %s", err)
+	}
+
+	s.batchSeq++
+	batch.ID = s.batchSeq
+	batch.CreatedAt = time.Now()
+
+	return &s3Stage{storage: s, batch: batch}, nil
+}
+
+// DeleteBatch deletes the batch object first, then the objects of the batch and of the blobs it
+// no longer references. The batch is read until its batch object is deleted.
+func (s *S3Storage) DeleteBatch(ctx context.Context, id int) error {
+	s.mu.RLock()
+	stored, found := s.batches[id]
+	s.mu.RUnlock()
+	if !found {
+		return ErrBatchNotFound
+	}
+
+	key := s.objectKey(s.batchObject(id, s3BatchObject))
+	if err := s.client.RemoveObject(ctx, s.config.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("unable to delete %s: %s", key, err)
+	}
+
+	// the blob references are released once, by the deletion which removes the batch.
+	s.mu.Lock()
+	if s.batches[id] != stored {
+		s.mu.Unlock()
+
+		return ErrBatchNotFound
+	}
+
+	delete(s.batches, id)
+	var unreferencedKeys []string
+	for _, file := range stored.Files {
+		if unreferenced, _ := s.releaseBlob(file.BlobKey); unreferenced {
+			unreferencedKeys = append(unreferencedKeys, file.BlobKey)
+		}
+	}
+	s.mu.Unlock()
+
+	s.removeBatchObjects(ctx, id)
+
+	return s.removeBlobs(ctx, unreferencedKeys...)
+}
+
+// View holds the read lock while the function runs, so the blobs it reads can't be deleted by a
+// commit or a deletion meanwhile.
+func (s *S3Storage) View(ctx context.Context, fn func(Snapshot) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(s3Snapshot{ctx: ctx, storage: s})
+}
+
+// s3Snapshot reads the storage while its read lock is held by the View call.
+type s3Snapshot struct {
+	ctx     context.Context
+	storage *S3Storage
+}
+
+func (sn s3Snapshot) Version() (version int) {
+	for id := range sn.storage.batches {
+		version = max(version, id)
+	}
+
+	return
+}
+
+func (sn s3Snapshot) RetrieveBlob(key string) ([]byte, error) {
+	if sn.storage.blobRefs[key] == 0 {
+		return nil, ErrBlobNotFound
+	}
+
+	return sn.storage.getObject(sn.ctx, sn.storage.blobKey(key))
+}
+
+func (sn s3Snapshot) RetrieveBatches() ([]Batch, error) {
+	batches := make([]Batch, 0, len(sn.storage.batches))
+	for _, stored := range sn.storage.batches {
+		batches = append(batches, stored.Batch)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID < batches[j].ID })
+
+	return batches, nil
+}
+
+func (sn s3Snapshot) RetrieveBatch(id int) (Batch, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return Batch{}, err
+	}
+
+	return stored.Batch, nil
+}
+
+func (sn s3Snapshot) RetrieveFileByIndex(batchID, i int) (StoredFile, error) {
+	stored, found := sn.storage.batches[batchID]
+	if !found || i < 1 || i > len(stored.Files) {
+		return StoredFile{}, ErrStoredFileNotFound
+	}
+
+	return stored.Files[i-1], nil
+}
+
+func (sn s3Snapshot) RetrieveBatchFiles(id int) ([]StoredFile, error) {
+	stored, err := sn.batch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.Files, nil
+}
+
+func (sn s3Snapshot) RetrieveFiles(batchID, fromIndex, limit int) ([]StoredFile, error) {
+	stored, err := sn.batch(batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := stored.Files[min(max(fromIndex, 1)-1, len(stored.Files)):]
+
+	return files[:min(limit, len(files))], nil
+}
+
+func (sn s3Snapshot) RetrieveTree(id int) (tree *merkle.Tree, err error) {
+	if _, err = sn.batch(id); err != nil {
+		return
+	}
+
+	key := sn.storage.objectKey(sn.storage.batchObject(id, s3TreeObject))
+	if err = sn.storage.getJsonObject(sn.ctx, key, &tree); err != nil {
+		err = fmt.Errorf("unable to read the stored tree of batch %d: %s", id, err)
+	}
+
+	return
+}
+
+func (sn s3Snapshot) RetrieveDag(id int) (*dag.Node, error) {
+	if _, err := sn.batch(id); err != nil {
+		return nil, err
+	}
+
+	var dagRecords []dagRecord
+	key := sn.storage.objectKey(sn.storage.batchObject(id, s3DagObject))
+	if err := sn.storage.getJsonObject(sn.ctx, key, &dagRecords); err != nil {
+		return nil, fmt.Errorf("unable to read the stored dag of batch %d: %s", id, err)
+	}
+
+	return unflattenDag(dagRecords)
+}
+
+func (sn s3Snapshot) RetrieveTreeHead(id int) (sth.SignedTreeHead, error) {
+	stored, found := sn.storage.batches[id]
+	if !found {
+		return sth.SignedTreeHead{}, ErrTreeHeadNotFound
+	}
+
+	return stored.Head, nil
+}
+
+func (sn s3Snapshot) batch(id int) (*s3Batch, error) {
+	stored, found := sn.storage.batches[id]
+	if !found {
+		return nil, ErrBatchNotFound
+	}
+
+	return stored, nil
+}
+
+// putObject uploads the content of the size, -1 if unknown, in parts of the configured size once
+// it is larger than a part, so the parts are streamed to the bucket one after the other.
+func (s *S3Storage) putObject(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.config.Bucket, key, content, size,
+		minio.PutObjectOptions{
+			ContentType: contentType,
+			PartSize:    s.config.PartSize,
+			// the payloads are not signed, blobs are addressed by their hash and checked against the
+			// tree anyway, and not every S3 compatible server decodes the chunked streaming signature.
+			DisableContentSha256: true,
+		},
+	)
+
+	return err
+}
+
+// copyObject copies the object of the size within the bucket, the objects larger than a single
+// copy allows are copied part by part.
+func (s *S3Storage) copyObject(ctx context.Context, srcKey, dstKey string, size int64) error {
+	src := minio.CopySrcOptions{Bucket: s.config.Bucket, Object: srcKey}
+	dst := minio.CopyDestOptions{Bucket: s.config.Bucket, Object: dstKey}
+
+	var err error
+	if size <= maxCopyObjectSize {
+		_, err = s.client.CopyObject(ctx, dst, src)
+	} else {
+		_, err = s.client.ComposeObject(ctx, dst, src)
+	}
+
+	return err
+}
+
+// getJsonObject decodes the json object of the key, the decoded value is left untouched if there
+// is no such object.
+func (s *S3Storage) getJsonObject(ctx context.Context, key string, decoded any) error {
+	content, err := s.getObject(ctx, key)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, decoded)
+}
+
+func (s *S3Storage) putJsonObject(ctx context.Context, key string, value any) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.putObject(ctx, key, bytes.NewReader(content), int64(len(content)), "application/json")
+}
+
+func (s *S3Storage) getObject(ctx context.Context, key string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = object.Close() }()
+
+	return io.ReadAll(object)
+}
+
+// removeBatchObjects deletes the tree and dag objects of the batch, an object left behind is only
+// logged as it is never read again.
+func (s *S3Storage) removeBatchObjects(ctx context.Context, batchID int) {
+	for _, name := range []string{s3TreeObject, s3DagObject} {
+		key := s.objectKey(s.batchObject(batchID, name))
+		if err := s.client.RemoveObject(ctx, s.config.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("unable to delete %s: %s\n", key, err)
+		}
+	}
+}
+
+// batchObject returns the name of an object of the batch.
+func (s *S3Storage) batchObject(batchID int, name string) string {
+	return path.Join(s3BatchesPrefix, strconv.Itoa(batchID), name)
+}
+
+func (s *S3Storage) objectKey(name string) string {
+	return path.Join(s.config.Prefix, name)
+}
+
+func (s *S3Storage) blobKey(key string) string {
+	return path.Join(s.config.Prefix, s3BlobsPrefix, key)
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+
+	return len(p), nil
+}
+
+// newObjectID returns a random object name.
+func newObjectID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
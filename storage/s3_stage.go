@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// s3Stage keeps the staged batch in memory, its tree and dag objects are written by the commit
+// before the batch object which makes them visible.
+type s3Stage struct {
+	storage *S3Storage
+	batch   Batch
+	files   []StoredFile
+	tree    *merkle.Tree
+	dag     *dag.Node
+	done    bool
+}
+
+func (st *s3Stage) Batch() Batch {
+	return st.batch
+}
+
+func (st *s3Stage) StoreFile(_ context.Context, file StoredFile) (int, error) {
+	if st.done {
+		return 0, ErrStageDone
+	}
+
+	file.BatchID = st.batch.ID
+	file.Index = len(st.files) + 1
+	st.files = append(st.files, file)
+
+	return file.Index, nil
+}
+
+func (st *s3Stage) StoreTree(_ context.Context, tree *merkle.Tree) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	st.tree = tree
+
+	return nil
+}
+
+func (st *s3Stage) StoreDag(_ context.Context, root *dag.Node) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	st.dag = root
+
+	return nil
+}
+
+// Commit writes the tree and dag objects, then the batch object which makes the batch visible.
+func (st *s3Stage) Commit(ctx context.Context, head sth.SignedTreeHead) error {
+	if st.done {
+		return ErrStageDone
+	}
+
+	s := st.storage
+	if err := s.putJsonObject(ctx, s.objectKey(s.batchObject(st.batch.ID, s3TreeObject)), st.tree); err != nil {
+		return fmt.Errorf("unable to write the tree: %s", err)
+	}
+
+	if err := s.putJsonObject(ctx, s.objectKey(s.batchObject(st.batch.ID, s3DagObject)), flattenDag(st.dag)); err != nil {
+		s.removeBatchObjects(ctx, st.batch.ID)
+
+		return fmt.Errorf("unable to write the dag: %s", err)
+	}
+
+	// the files take their blob references before the batch object is written, so the blobs can't
+	// be deleted meanwhile.
+	blobKeys := make([]string, len(st.files))
+	s.mu.Lock()
+	for i, file := range st.files {
+		if s.blobRefs[file.BlobKey] == 0 {
+			s.mu.Unlock()
+			s.removeBatchObjects(ctx, st.batch.ID)
+
+			return ErrBlobNotFound
+		}
+
+		blobKeys[i] = file.BlobKey
+		st.batch.Size += file.Size
+	}
+	for _, key := range blobKeys {
+		s.blobRefs[key]++
+	}
+	s.mu.Unlock()
+	st.batch.FileCount = len(st.files)
+
+	stored := &s3Batch{Batch: st.batch, Files: st.files, Head: head}
+	if err := s.putJsonObject(ctx, s.objectKey(s.batchObject(st.batch.ID, s3BatchObject)), stored); err != nil {
+		s.removeBatchObjects(ctx, st.batch.ID)
+
+		return errors.Join(fmt.Errorf("unable to write the batch: %s", err), s.releaseBlobs(ctx, blobKeys...))
+	}
+
+	s.mu.Lock()
+	s.batches[st.batch.ID] = stored
+	s.mu.Unlock()
+	st.done = true
+
+	return nil
+}
+
+func (st *s3Stage) Rollback(_ context.Context) error {
+	st.done = true
+
+	return nil
+}
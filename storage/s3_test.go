@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/hash"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// The s3 tests run against an in-memory S3 server started for each test, or against the S3
+// compatible server at S3_TEST_ENDPOINT if set, such as a MinIO server started with
+// `docker run -p 9000:9000 minio/minio server /data`. Every test stores its objects under its own
+// prefix of the S3_TEST_BUCKET bucket.
+func newTestS3Config(t *testing.T) S3Config {
+	t.Helper()
+
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		fakeServer := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+		t.Cleanup(fakeServer.Close)
+
+		endpoint = strings.TrimPrefix(fakeServer.URL, "http://")
+	}
+
+	config := S3Config{
+		Endpoint:  endpoint,
+		AccessKey: envOr("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("S3_TEST_SECRET_KEY", "minioadmin"),
+		Bucket:    envOr("S3_TEST_BUCKET", "fxmerkle-test"),
+		Prefix:    newObjectID(),
+		// the smallest part size, so multipart uploads are tested without large blobs.
+		PartSize: 5 << 20,
+	}
+	t.Cleanup(func() { removeTestObjects(t, config) })
+
+	return config
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func newTestS3Storage(t *testing.T, config S3Config) *S3Storage {
+	t.Helper()
+
+	s, err := NewS3Storage(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unable to create the s3 storage: %s", err)
+	}
+
+	return s
+}
+
+// testObjects returns the keys of the objects the storage stored under the prefix.
+func testObjects(t *testing.T, s *S3Storage, prefix string) []string {
+	t.Helper()
+
+	var keys []string
+	for object := range s.client.ListObjects(context.Background(), s.config.Bucket, minio.ListObjectsOptions{
+		Prefix:    path.Join(s.config.Prefix, prefix) + "/",
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			t.Fatalf("unable to list the objects: %s", object.Err)
+		}
+
+		keys = append(keys, object.Key)
+	}
+
+	return keys
+}
+
+func removeTestObjects(t *testing.T, config S3Config) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+	})
+	if err != nil {
+		t.Logf("unable to clean up the test objects: %s", err)
+
+		return
+	}
+
+	ctx := context.Background()
+	for object := range client.ListObjects(ctx, config.Bucket, minio.ListObjectsOptions{
+		Prefix:    config.Prefix + "/",
+		Recursive: true,
+	}) {
+		if object.Err == nil {
+			_ = client.RemoveObject(ctx, config.Bucket, object.Key, minio.RemoveObjectOptions{})
+		}
+	}
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	t.Helper()
+
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+// commitTestBatch commits a batch of the blobs, already stored, and releases the references held
+// on them.
+func commitTestBatch(t *testing.T, s *S3Storage, blobKeys ...string) Batch {
+	t.Helper()
+
+	ctx := context.Background()
+	stage, err := s.StageBatch(ctx, Batch{})
+	if err != nil {
+		t.Fatalf("unable to stage a batch: %s", err)
+	}
+
+	leafHashes := make(hash.HashList, len(blobKeys))
+	for i, key := range blobKeys {
+		if _, err = stage.StoreFile(ctx, StoredFile{Name: key, BlobKey: key}); err != nil {
+			t.Fatalf("unable to stage a file: %s", err)
+		}
+
+		leafHashes[i] = []byte(key)
+	}
+
+	tree, err := merkle.NewTreeFromLeafHashes(leafHashes, hash.NewSha256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = stage.StoreTree(ctx, tree); err != nil {
+		t.Fatalf("unable to stage the tree: %s", err)
+	}
+
+	batch := stage.Batch()
+	if err = stage.Commit(ctx, sth.SignedTreeHead{TreeHead: sth.TreeHead{BatchID: batch.ID, Root: tree.RootHex()}}); err != nil {
+		t.Fatalf("unable to commit the batch: %s", err)
+	}
+
+	for _, key := range blobKeys {
+		if err = s.ReleaseBlob(ctx, key); err != nil {
+			t.Fatalf("unable to release blob %s: %s", key, err)
+		}
+	}
+
+	return batch
+}
+
+func TestS3StorageStoresBlobsOnce(t *testing.T) {
+	config := newTestS3Config(t)
+	s := newTestS3Storage(t, config)
+	ctx := context.Background()
+
+	// larger than a part, so it is uploaded as multipart.
+	content := randomContent(t, 6<<20)
+	key, err := s.StoreBlob(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unable to store the blob: %s", err)
+	}
+	if key != BlobKey(content) {
+		t.Fatalf("blob stored as %s, expected %s", key, BlobKey(content))
+	}
+
+	if _, err = s.StoreBlob(ctx, bytes.NewReader(content)); err != nil {
+		t.Fatalf("unable to store the blob again: %s", err)
+	}
+
+	if objects := testObjects(t, s, s3BlobsPrefix); len(objects) != 1 {
+		t.Fatalf("the blob stored twice left %d objects, expected 1", len(objects))
+	}
+	if objects := testObjects(t, s, s3UploadsPrefix); len(objects) != 0 {
+		t.Fatalf("the uploads left %d objects behind", len(objects))
+	}
+
+	if err = s.ReleaseBlob(ctx, key); err != nil {
+		t.Fatalf("unable to release the blob: %s", err)
+	}
+
+	stored, err := s.RetrieveBlob(ctx, key)
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Fatalf("the blob still referenced is not retrieved: %v", err)
+	}
+
+	if err = s.ReleaseBlob(ctx, key); err != nil {
+		t.Fatalf("unable to release the blob: %s", err)
+	}
+
+	if _, err = s.RetrieveBlob(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("the released blob is retrieved: %v", err)
+	}
+	if objects := testObjects(t, s, s3BlobsPrefix); len(objects) != 0 {
+		t.Fatalf("the released blob left %d objects", len(objects))
+	}
+}
+
+func TestS3StorageReloadsCommittedBatches(t *testing.T) {
+	config := newTestS3Config(t)
+	s := newTestS3Storage(t, config)
+	ctx := context.Background()
+
+	shared := randomContent(t, 1024)
+	var blobKeys []string
+	for _, content := range [][]byte{shared, randomContent(t, 2048), shared} {
+		key, err := s.StoreBlob(ctx, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("unable to store a blob: %s", err)
+		}
+
+		blobKeys = append(blobKeys, key)
+	}
+
+	first := commitTestBatch(t, s, blobKeys[0], blobKeys[1])
+	second := commitTestBatch(t, s, blobKeys[2])
+
+	// a blob stored by an upload which never committed is deleted on reload.
+	if _, err := s.StoreBlob(ctx, bytes.NewReader(randomContent(t, 512))); err != nil {
+		t.Fatalf("unable to store a blob: %s", err)
+	}
+
+	reloaded := newTestS3Storage(t, config)
+	err := reloaded.View(ctx, func(snapshot Snapshot) error {
+		if version := snapshot.Version(); version != second.ID {
+			t.Errorf("reloaded version %d, expected %d", version, second.ID)
+		}
+
+		files, err := snapshot.RetrieveBatchFiles(first.ID)
+		if err != nil || len(files) != 2 {
+			t.Errorf("reloaded batch %d with %d files: %v", first.ID, len(files), err)
+		}
+
+		tree, err := snapshot.RetrieveTree(first.ID)
+		if err != nil || tree == nil || tree.Size != 2 {
+			t.Errorf("reloaded tree of batch %d: %+v, %v", first.ID, tree, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if objects := testObjects(t, s, s3BlobsPrefix); len(objects) != 2 {
+		t.Fatalf("the reload left %d blob objects, expected 2", len(objects))
+	}
+
+	// the shared blob is held by both batches, the other one only by the first batch.
+	if err = reloaded.DeleteBatch(ctx, first.ID); err != nil {
+		t.Fatalf("unable to delete batch %d: %s", first.ID, err)
+	}
+
+	if _, err = reloaded.RetrieveBlob(ctx, blobKeys[0]); err != nil {
+		t.Fatalf("the blob held by batch %d is not retrieved: %s", second.ID, err)
+	}
+	if _, err = reloaded.RetrieveBlob(ctx, blobKeys[1]); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("the blob of the deleted batch is retrieved: %v", err)
+	}
+
+	if err = reloaded.DeleteBatch(ctx, second.ID); err != nil {
+		t.Fatalf("unable to delete batch %d: %s", second.ID, err)
+	}
+
+	// the ids of the deleted batches are not given again.
+	stage, err := newTestS3Storage(t, config).StageBatch(ctx, Batch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := stage.Batch().ID; id <= second.ID {
+		t.Fatalf("staged batch %d after deleting batch %d", id, second.ID)
+	}
+
+	if objects := testObjects(t, s, ""); len(objects) != 1 {
+		t.Fatalf("the deleted batches left %d objects, expected the batch sequence only: %v", len(objects), objects)
+	}
+}
+
+func TestS3StorageNeverReusesBatchIDs(t *testing.T) {
+	config := newTestS3Config(t)
+	s := newTestS3Storage(t, config)
+	ctx := context.Background()
+
+	stage, err := s.StageBatch(ctx, Batch{})
+	if err != nil {
+		t.Fatalf("unable to stage a batch: %s", err)
+	}
+	if err = stage.Rollback(ctx); err != nil {
+		t.Fatalf("unable to roll back the batch: %s", err)
+	}
+
+	// the id is reserved before the batch is staged, a reloaded storage doesn't give it again.
+	rolledBack := stage.Batch().ID
+	if stage, err = newTestS3Storage(t, config).StageBatch(ctx, Batch{}); err != nil {
+		t.Fatalf("unable to stage a batch: %s", err)
+	}
+	if id := stage.Batch().ID; id <= rolledBack {
+		t.Fatalf("staged batch %d after batch %d was rolled back", id, rolledBack)
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var _ Repository = (*SQLiteStorage)(nil)
+
+// status of a batch row.
+const (
+	batchStaged = iota
+	batchCommitted
+)
+
+// SQLiteStorage keeps the batches metadata, trees and blobs in an embedded sqlite database.
+// A batch is staged by several calls but is only visible once committed, so a failed upload never
+// leaves a half-written batch behind.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage opens the sqlite database at the path, creating it if needed, and migrates
+// its schema to the latest version.
+func NewSQLiteStorage(ctx context.Context, dbPath string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf(
+		"file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", dbPath,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the sqlite database %s: %s", dbPath, err)
+	}
+
+	// sqlite serializes the writes anyway, a single connection avoids busy errors between them.
+	db.SetMaxOpenConns(1)
+
+	if err = migrate(ctx, db); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	s := &SQLiteStorage{db: db}
+	if err = s.rollbackStaged(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("unable to roll back the batches staged before start up: %s", err)
+	}
+
+	if err = s.recountBlobRefs(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("unable to count the blob references: %s", err)
+	}
+
+	return s, nil
+}
+
+// recountBlobRefs counts the blob references of the stored files again and deletes the blobs no
+// longer referenced, the references held by the uploads interrupted by a restart are lost.
+func (s *SQLiteStorage) recountBlobRefs(ctx context.Context) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE blobs SET refs = (SELECT COUNT(*) FROM files WHERE blob_key = blobs.key)")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM blobs WHERE refs = 0")
+
+		return err
+	})
+}
+
+// rollbackStaged rolls back the batches left staged by uploads which never ended.
+func (s *SQLiteStorage) rollbackStaged(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM batches WHERE status = ?", batchStaged)
+	if err != nil {
+		return err
+	}
+
+	var batchIDs []int
+	for rows.Next() {
+		var batchID int
+		if err = rows.Scan(&batchID); err != nil {
+			_ = rows.Close()
+
+			return err
+		}
+
+		batchIDs = append(batchIDs, batchID)
+	}
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return err
+	}
+
+	for _, batchID := range batchIDs {
+		if err = inTx(ctx, s.db, func(tx *sql.Tx) error { return deleteBatch(ctx, tx, batchID) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// StoreBlob reads the whole content first, the content is stored in a single column.
+func (s *SQLiteStorage) StoreBlob(ctx context.Context, reader io.Reader) (string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	key := BlobKey(content)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO blobs (key, content, refs) VALUES (?, ?, 1)
+		ON CONFLICT (key) DO UPDATE SET refs = refs + 1`,
+		key, content,
+	)
+
+	return key, err
+}
+
+func (s *SQLiteStorage) RetrieveBlob(ctx context.Context, key string) ([]byte, error) {
+	return viewValue(ctx, s, func(snapshot Snapshot) ([]byte, error) { return snapshot.RetrieveBlob(key) })
+}
+
+func (s *SQLiteStorage) ReleaseBlob(ctx context.Context, key string) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		return releaseBlob(ctx, tx, key, 1)
+	})
+}
+
+// releaseBlob drops references to the blob and deletes it once it is no longer referenced.
+func releaseBlob(ctx context.Context, tx *sql.Tx, key string, refs int) error {
+	result, err := tx.ExecContext(ctx, "UPDATE blobs SET refs = refs - ? WHERE key = ?", refs, key)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return errors.Join(ErrBlobNotFound, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM blobs WHERE key = ? AND refs <= 0", key)
+
+	return err
+}
+
+func (s *SQLiteStorage) StageBatch(ctx context.Context, batch Batch) (BatchStage, error) {
+	batch.CreatedAt = time.Now()
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO batches (created_at, ordering, leaf_format, dag_root, status) VALUES (?, ?, ?, ?, ?)
+		RETURNING id`,
+		batch.CreatedAt.UnixNano(), batch.Ordering, batch.LeafFormat, batch.DagRoot, batchStaged,
+	).Scan(&batch.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteStage{db: s.db, batch: batch}, nil
+}
+
+func (s *SQLiteStorage) DeleteBatch(ctx context.Context, id int) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var status int
+		err := tx.QueryRowContext(ctx, "SELECT status FROM batches WHERE id = ?", id).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && status != batchCommitted) {
+			return ErrBatchNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return deleteBatch(ctx, tx, id)
+	})
+}
+
+// View runs the function in a read transaction, the database is read as of its first query.
+func (s *SQLiteStorage) View(ctx context.Context, fn func(Snapshot) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	snapshot := sqliteSnapshot{ctx: ctx, tx: tx, committed: make(map[int]bool)}
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM batches WHERE status = ?", batchCommitted)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var batchID int
+		if err = rows.Scan(&batchID); err != nil {
+			_ = rows.Close()
+
+			return err
+		}
+
+		snapshot.committed[batchID] = true
+		snapshot.version = max(snapshot.version, batchID)
+	}
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return err
+	}
+
+	return fn(snapshot)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row rowScanner) (file StoredFile, err error) {
+	err = row.Scan(
+		&file.Index, &file.BatchID, &file.Name, &file.BlobKey, &file.Size, &file.Mode, &file.ModTime, &file.ContentType,
+	)
+
+	return
+}
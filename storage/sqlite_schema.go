@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are the schema changes of the sqlite database, in order. The schema version is the
+// number of applied migrations, kept in the user_version pragma. Never edit an applied migration,
+// append a new one instead.
+var migrations = []string{
+	`CREATE TABLE blobs (
+		key     TEXT PRIMARY KEY,
+		content BLOB NOT NULL,
+		refs    INTEGER NOT NULL
+	);
+
+	CREATE TABLE batches (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at  INTEGER NOT NULL,
+		ordering    TEXT NOT NULL,
+		leaf_format TEXT NOT NULL,
+		dag_root    TEXT NOT NULL,
+		committed   INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE files (
+		idx      INTEGER PRIMARY KEY,
+		batch_id INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+		name     TEXT NOT NULL,
+		blob_key TEXT NOT NULL REFERENCES blobs (key),
+		size     INTEGER NOT NULL,
+		mode     INTEGER NOT NULL,
+		mod_time INTEGER NOT NULL
+	);
+	CREATE INDEX files_batch_id ON files (batch_id);
+
+	CREATE TABLE tree_leaves (
+		batch_id INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		data     BLOB NOT NULL,
+		PRIMARY KEY (batch_id, position)
+	);
+
+	CREATE TABLE tree_nodes (
+		batch_id INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		hash     BLOB NOT NULL,
+		PRIMARY KEY (batch_id, position)
+	);
+
+	CREATE TABLE dag_nodes (
+		batch_id INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+		path     TEXT NOT NULL,
+		kind     TEXT NOT NULL,
+		hash     BLOB NOT NULL,
+		size     INTEGER NOT NULL,
+		PRIMARY KEY (batch_id, path)
+	);
+
+	CREATE TABLE tree_heads (
+		batch_id  INTEGER PRIMARY KEY REFERENCES batches (id) ON DELETE CASCADE,
+		tree_head TEXT NOT NULL
+	);`,
+	// files are indexed per batch, so a staged batch is indexed from 1 next to the committed one,
+	// and batches keep whether they are staged, committed or replaced by a later batch.
+	`CREATE TABLE files_by_batch (
+		batch_id INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+		idx      INTEGER NOT NULL,
+		name     TEXT NOT NULL,
+		blob_key TEXT NOT NULL REFERENCES blobs (key),
+		size     INTEGER NOT NULL,
+		mode     INTEGER NOT NULL,
+		mod_time INTEGER NOT NULL,
+		PRIMARY KEY (batch_id, idx)
+	);
+	INSERT INTO files_by_batch (batch_id, idx, name, blob_key, size, mode, mod_time)
+	SELECT batch_id, idx, name, blob_key, size, mode, mod_time FROM files;
+	DROP TABLE files;
+	ALTER TABLE files_by_batch RENAME TO files;
+
+	ALTER TABLE batches RENAME COLUMN committed TO status;
+	UPDATE batches SET status = 2
+	WHERE status = 1 AND id < (SELECT MAX(id) FROM batches WHERE status = 1);`,
+	// batches are kept side by side now, the batches replaced before only kept their metadata.
+	`DELETE FROM batches WHERE status = 2;`,
+	`ALTER TABLE files ADD COLUMN content_type TEXT NOT NULL DEFAULT '';`,
+	// the tree leaves are only kept as the hashes of the tree nodes, the files hold their data.
+	`DROP TABLE tree_leaves;`,
+}
+
+// migrate applies the migrations the database is missing, each one in its own transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("unable to read the schema version: %s", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+				return err
+			}
+
+			// pragmas can't take bound parameters.
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1))
+
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to migrate the schema to version %d: %s", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+// inTx runs the function in a transaction, committed if the function succeeds and rolled back otherwise.
+func inTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
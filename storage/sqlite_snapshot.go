@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// sqliteSnapshot reads the committed batches in the read transaction of the View call.
+type sqliteSnapshot struct {
+	ctx       context.Context
+	tx        *sql.Tx
+	committed map[int]bool
+	version   int
+}
+
+func (sn sqliteSnapshot) Version() int {
+	return sn.version
+}
+
+func (sn sqliteSnapshot) RetrieveBlob(key string) (content []byte, err error) {
+	err = sn.tx.QueryRowContext(sn.ctx, "SELECT content FROM blobs WHERE key = ?", key).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrBlobNotFound
+	}
+
+	return
+}
+
+// selectBatches selects the batches along with the size and count of their files.
+const selectBatches = `
+	SELECT b.id, b.created_at, b.ordering, b.leaf_format, b.dag_root, COALESCE(SUM(f.size), 0), COUNT(f.idx)
+	FROM batches b LEFT JOIN files f ON f.batch_id = b.id
+	WHERE b.status = ?`
+
+func (sn sqliteSnapshot) RetrieveBatches() (batches []Batch, err error) {
+	rows, err := sn.tx.QueryContext(sn.ctx, selectBatches+" GROUP BY b.id ORDER BY b.id", batchCommitted)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var batch Batch
+		if batch, err = scanBatch(rows); err != nil {
+			return
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, rows.Err()
+}
+
+func (sn sqliteSnapshot) RetrieveBatch(id int) (batch Batch, err error) {
+	if !sn.committed[id] {
+		return Batch{}, ErrBatchNotFound
+	}
+
+	return scanBatch(sn.tx.QueryRowContext(sn.ctx, selectBatches+" AND b.id = ? GROUP BY b.id", batchCommitted, id))
+}
+
+const selectFiles = "SELECT idx, batch_id, name, blob_key, size, mode, mod_time, content_type FROM files"
+
+func (sn sqliteSnapshot) RetrieveFileByIndex(batchID, i int) (StoredFile, error) {
+	if !sn.committed[batchID] {
+		return StoredFile{}, ErrStoredFileNotFound
+	}
+
+	row := sn.tx.QueryRowContext(sn.ctx, selectFiles+" WHERE batch_id = ? AND idx = ?", batchID, i)
+
+	storedFile, err := scanFile(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrStoredFileNotFound
+	}
+
+	return storedFile, err
+}
+
+func (sn sqliteSnapshot) RetrieveBatchFiles(id int) (files []StoredFile, err error) {
+	if !sn.committed[id] {
+		return nil, ErrBatchNotFound
+	}
+
+	rows, err := sn.tx.QueryContext(sn.ctx, selectFiles+" WHERE batch_id = ? ORDER BY idx", id)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var file StoredFile
+		if file, err = scanFile(rows); err != nil {
+			return
+		}
+
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+func (sn sqliteSnapshot) RetrieveFiles(batchID, fromIndex, limit int) (files []StoredFile, err error) {
+	if !sn.committed[batchID] {
+		return nil, ErrBatchNotFound
+	}
+
+	rows, err := sn.tx.QueryContext(sn.ctx,
+		selectFiles+" WHERE batch_id = ? AND idx >= ? ORDER BY idx LIMIT ?", batchID, fromIndex, limit,
+	)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var file StoredFile
+		if file, err = scanFile(rows); err != nil {
+			return
+		}
+
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+func (sn sqliteSnapshot) RetrieveTree(id int) (*merkle.Tree, error) {
+	if !sn.committed[id] {
+		return nil, ErrBatchNotFound
+	}
+
+	// the tree has a leaf per file of the batch.
+	tree := &merkle.Tree{}
+	if err := sn.tx.QueryRowContext(sn.ctx, "SELECT COUNT(*) FROM files WHERE batch_id = ?", id).Scan(&tree.Size); err != nil {
+		return nil, err
+	}
+
+	nodes, err := sn.tx.QueryContext(sn.ctx, "SELECT position, hash FROM tree_nodes WHERE batch_id = ? ORDER BY position", id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = nodes.Close() }()
+
+	for nodes.Next() {
+		var position int
+		var nodeHash []byte
+		if err = nodes.Scan(&position, &nodeHash); err != nil {
+			return nil, err
+		}
+
+		for len(tree.Nodes) <= position {
+			tree.Nodes = append(tree.Nodes, nil)
+		}
+
+		tree.Nodes[position] = nodeHash
+	}
+	if err = nodes.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tree.Nodes) == 0 {
+		return nil, nil
+	}
+
+	return tree, nil
+}
+
+func (sn sqliteSnapshot) RetrieveDag(id int) (*dag.Node, error) {
+	if !sn.committed[id] {
+		return nil, ErrBatchNotFound
+	}
+
+	rows, err := sn.tx.QueryContext(sn.ctx, "SELECT path, kind, hash, size FROM dag_nodes WHERE batch_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []dagRecord
+	for rows.Next() {
+		var record dagRecord
+		if err = rows.Scan(&record.Path, &record.Kind, &record.Hash, &record.Size); err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return unflattenDag(records)
+}
+
+func (sn sqliteSnapshot) RetrieveTreeHead(id int) (head sth.SignedTreeHead, err error) {
+	if !sn.committed[id] {
+		err = ErrTreeHeadNotFound
+
+		return
+	}
+
+	var treeHeadJson string
+	err = sn.tx.QueryRowContext(sn.ctx, "SELECT tree_head FROM tree_heads WHERE batch_id = ?", id).Scan(&treeHeadJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrTreeHeadNotFound
+
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal([]byte(treeHeadJson), &head)
+
+	return
+}
+
+func scanBatch(row rowScanner) (batch Batch, err error) {
+	var createdAt int64
+	err = row.Scan(
+		&batch.ID, &createdAt, &batch.Ordering, &batch.LeafFormat, &batch.DagRoot, &batch.Size, &batch.FileCount,
+	)
+	batch.CreatedAt = time.Unix(0, createdAt)
+
+	return
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// sqliteStage writes the rows of a staged batch, they stay hidden behind its status until the
+// commit flips it in the same transaction as it stores the tree head.
+type sqliteStage struct {
+	db    *sql.DB
+	batch Batch
+}
+
+func (st *sqliteStage) Batch() Batch {
+	return st.batch
+}
+
+// StoreFile stages the file, the staged file holds a reference to its blob until rolled back.
+func (st *sqliteStage) StoreFile(ctx context.Context, file StoredFile) (index int, err error) {
+	err = inTx(ctx, st.db, func(tx *sql.Tx) error {
+		if err := st.checkStaged(ctx, tx); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, "UPDATE blobs SET refs = refs + 1 WHERE key = ?", file.BlobKey)
+		if err != nil {
+			return err
+		}
+
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			return errors.Join(ErrBlobNotFound, err)
+		}
+
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO files (batch_id, idx, name, blob_key, size, mode, mod_time, content_type)
+			SELECT ?, COALESCE(MAX(idx), 0) + 1, ?, ?, ?, ?, ?, ? FROM files WHERE batch_id = ?
+			RETURNING idx`,
+			st.batch.ID, file.Name, file.BlobKey, file.Size, file.Mode, file.ModTime, file.ContentType, st.batch.ID,
+		).Scan(&index)
+	})
+
+	return
+}
+
+// StoreTree stages the nodes of the tree.
+func (st *sqliteStage) StoreTree(ctx context.Context, tree *merkle.Tree) error {
+	return inTx(ctx, st.db, func(tx *sql.Tx) error {
+		if err := st.checkStaged(ctx, tx); err != nil {
+			return err
+		}
+
+		// the leaves are only kept as their hashes, the tree size is the number of files of the
+		// batch. The first node is unused, the root is the second one.
+		for position, nodeHash := range tree.Nodes {
+			if nodeHash == nil {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO tree_nodes (batch_id, position, hash) VALUES (?, ?, ?)", st.batch.ID, position, nodeHash,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// StoreDag stages the dag node by node under their paths.
+func (st *sqliteStage) StoreDag(ctx context.Context, root *dag.Node) error {
+	return inTx(ctx, st.db, func(tx *sql.Tx) error {
+		if err := st.checkStaged(ctx, tx); err != nil {
+			return err
+		}
+
+		for _, record := range flattenDag(root) {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO dag_nodes (batch_id, path, kind, hash, size) VALUES (?, ?, ?, ?, ?)",
+				st.batch.ID, record.Path, record.Kind, []byte(record.Hash), record.Size,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Commit stores the tree head and commits the batch.
+func (st *sqliteStage) Commit(ctx context.Context, head sth.SignedTreeHead) error {
+	treeHeadJson, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, st.db, func(tx *sql.Tx) error {
+		if err := st.checkStaged(ctx, tx); err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx,
+			"INSERT INTO tree_heads (batch_id, tree_head) VALUES (?, ?)", st.batch.ID, string(treeHeadJson),
+		); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE batches SET status = ? WHERE id = ?", batchCommitted, st.batch.ID)
+
+		return err
+	})
+}
+
+// Rollback deletes the staged rows and releases the blobs of the staged files.
+func (st *sqliteStage) Rollback(ctx context.Context) error {
+	return inTx(ctx, st.db, func(tx *sql.Tx) error {
+		if err := st.checkStaged(ctx, tx); errors.Is(err, ErrStageDone) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		return deleteBatch(ctx, tx, st.batch.ID)
+	})
+}
+
+func (st *sqliteStage) checkStaged(ctx context.Context, tx *sql.Tx) error {
+	var status int
+	err := tx.QueryRowContext(ctx, "SELECT status FROM batches WHERE id = ?", st.batch.ID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && status != batchStaged) {
+		return ErrStageDone
+	}
+
+	return err
+}
+
+// deleteBatch deletes the batch along with its files, tree and dag.
+func deleteBatch(ctx context.Context, tx *sql.Tx, batchID int) error {
+	if err := deleteBatchContent(ctx, tx, batchID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "DELETE FROM batches WHERE id = ?", batchID)
+
+	return err
+}
+
+// deleteBatchContent deletes the files, tree and dag of the batch and releases the blobs of the files.
+func deleteBatchContent(ctx context.Context, tx *sql.Tx, batchID int) error {
+	blobRefs, err := tx.QueryContext(ctx,
+		"SELECT blob_key, COUNT(*) FROM files WHERE batch_id = ? GROUP BY blob_key", batchID,
+	)
+	if err != nil {
+		return err
+	}
+
+	refs := make(map[string]int)
+	for blobRefs.Next() {
+		var key string
+		var count int
+		if err = blobRefs.Scan(&key, &count); err != nil {
+			_ = blobRefs.Close()
+
+			return err
+		}
+
+		refs[key] = count
+	}
+	if err = errors.Join(blobRefs.Err(), blobRefs.Close()); err != nil {
+		return err
+	}
+
+	// the files go first, the blobs can't be deleted while referenced by them.
+	for _, table := range []string{"files", "tree_nodes", "dag_nodes"} {
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE batch_id = ?", table), batchID); err != nil {
+			return err
+		}
+	}
+
+	for key, count := range refs {
+		if err = releaseBlob(ctx, tx, key, count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSQLiteStorage(t *testing.T, dbPath string) *SQLiteStorage {
+	t.Helper()
+
+	s, err := NewSQLiteStorage(context.Background(), dbPath)
+	if err != nil {
+		t.Fatalf("unable to create the sqlite storage: %s", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func TestSQLiteStorageRecountsBlobRefsOnReopen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "fxmerkle.db")
+	s := newTestSQLiteStorage(t, dbPath)
+	ctx := context.Background()
+
+	shared := []byte("shared")
+	batchID, err := uploadTestBatch(ctx, s, [][]byte{shared, shared, []byte("other")})
+	if err != nil {
+		t.Fatalf("unable to upload a batch: %s", err)
+	}
+
+	// the references taken by uploads which never released them are lost on reopen.
+	leaked := []byte("leaked")
+	leakedKey, err := s.StoreBlob(ctx, bytes.NewReader(leaked))
+	if err != nil {
+		t.Fatalf("unable to store a blob: %s", err)
+	}
+	if _, err = s.StoreBlob(ctx, bytes.NewReader(shared)); err != nil {
+		t.Fatalf("unable to store a blob: %s", err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestSQLiteStorage(t, dbPath)
+	if _, err = reopened.RetrieveBlob(ctx, leakedKey); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("the blob of an interrupted upload is retrieved: %v", err)
+	}
+
+	var refs int
+	if err = reopened.db.QueryRowContext(ctx, "SELECT refs FROM blobs WHERE key = ?", BlobKey(shared)).Scan(&refs); err != nil {
+		t.Fatal(err)
+	}
+	if refs != 2 {
+		t.Fatalf("the shared blob has %d references, expected 2", refs)
+	}
+
+	if err = reopened.DeleteBatch(ctx, batchID); err != nil {
+		t.Fatalf("unable to delete batch %d: %s", batchID, err)
+	}
+	if _, err = reopened.RetrieveBlob(ctx, BlobKey(shared)); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("the blob of the deleted batch is retrieved: %v", err)
+	}
+}
+
+// TestSQLiteStorageMigratesTheFirstSchema opens a database of the first schema version, with a
+// batch replaced by the last one, as the first releases left it.
+func TestSQLiteStorageMigratesTheFirstSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "fxmerkle.db")
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("content")
+	for _, query := range []string{
+		migrations[0],
+		"PRAGMA user_version = 1",
+		"INSERT INTO blobs (key, content, refs) VALUES ('" + BlobKey(content) + "', 'content', 2)",
+		"INSERT INTO batches (created_at, ordering, leaf_format, dag_root, committed) VALUES (0, '', '', '', 1), (0, '', '', '', 1)",
+		"INSERT INTO files (idx, batch_id, name, blob_key, size, mode, mod_time) VALUES " +
+			"(1, 1, 'replaced', '" + BlobKey(content) + "', 7, 420, 0), (2, 2, 'last', '" + BlobKey(content) + "', 7, 420, 0)",
+	} {
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("unable to create the first schema: %s", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSQLiteStorage(t, dbPath)
+	err = s.View(ctx, func(snapshot Snapshot) error {
+		if _, err := snapshot.RetrieveBatchFiles(1); !errors.Is(err, ErrBatchNotFound) {
+			t.Errorf("the replaced batch is retrieved: %v", err)
+		}
+
+		files, err := snapshot.RetrieveBatchFiles(2)
+		if err != nil || len(files) != 1 || files[0].Name != "last" || files[0].ContentType != "" {
+			t.Errorf("migrated the files of the last batch to %+v: %v", files, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refs int
+	if err = s.db.QueryRowContext(ctx, "SELECT refs FROM blobs WHERE key = ?", BlobKey(content)).Scan(&refs); err != nil {
+		t.Fatal(err)
+	}
+	if refs != 1 {
+		t.Fatalf("the blob has %d references after the migration, expected 1", refs)
+	}
+}
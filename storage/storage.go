@@ -3,24 +3,118 @@ package storage
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+	"github.com/TxCorpi0x/file-upload-merkle/types"
 )
 
 var (
 	ErrStoredFileNotFound = errors.New("the file is not found in the storage")
+	ErrTreeHeadNotFound   = errors.New("no tree head has been committed yet")
+	ErrBatchNotFound      = errors.New("the batch is not found in the storage")
+	ErrBlobNotFound       = errors.New("the blob is not found in the storage")
+	ErrStageDone          = errors.New("the batch stage is already committed or rolled back")
 )
 
 type StoredFile struct {
 	Index   int
+	BatchID int
 	Name    string
-	Content []byte
+	// BlobKey is the key of the content blob, shared by every stored file with the same content.
+	BlobKey string
+	Size    int64
+	Mode    uint32
+	ModTime int64
+	// ContentType is the media type of the content, empty for the files stored before it was kept.
+	ContentType string
 }
 
+// Leaf returns the file with the content of its blob as committed to by a tree leaf.
+func (f StoredFile) Leaf(content []byte) merkle.FileLeaf {
+	return merkle.FileLeaf{Path: f.Name, Content: content, Mode: f.Mode, ModTime: f.ModTime}
+}
+
+// Batch is the set of files uploaded together, committed to by a single merkle tree.
+type Batch struct {
+	ID        int
+	CreatedAt time.Time
+	// Ordering is the order in which the files are placed as tree leaves.
+	Ordering types.Ordering
+	// LeafFormat is the format of the data committed to by the tree leaves.
+	LeafFormat merkle.LeafFormat
+	// DagRoot is the content identifier of the directory structured dag, empty if not built.
+	DagRoot string
+	// Size is the total size of the batch files and FileCount their number, set on commit.
+	Size      int64
+	FileCount int
+}
+
+// Repository stores the batches and their trees. File contents are stored once as blobs, every
+// stored file holds a reference to its blob and a blob is deleted once it is no longer referenced.
 type Repository interface {
+	// StoreBlob stores the content read from the reader, unless already stored, and returns its
+	// key along with a reference the caller must release once the blob is referenced by the
+	// stored files.
+	StoreBlob(context.Context, io.Reader) (string, error)
+	RetrieveBlob(context.Context, string) ([]byte, error)
+	ReleaseBlob(context.Context, string) error
+	// StageBatch starts a new batch, assigning its id and creation time. Nothing of it is visible
+	// until it is committed, then it is the last batch.
+	StageBatch(context.Context, Batch) (BatchStage, error)
+	// View calls the function with a snapshot of the committed batches, reads spanning several
+	// calls must go through it to see a single version. The function must not call the repository.
+	View(context.Context, func(Snapshot) error) error
+	// DeleteBatch deletes a committed batch along with its files, tree and tree head, the blobs of
+	// its files are released.
+	DeleteBatch(context.Context, int) error
+}
+
+// BatchStage is a batch being uploaded, it is either committed at once or rolled back.
+type BatchStage interface {
+	Batch() Batch
+	// StoreFile stages the file, its blob must already be stored. Files are indexed from 1 in the
+	// order they are staged.
 	StoreFile(context.Context, StoredFile) (int, error)
-	RetrieveFileByIndex(context.Context, int) (StoredFile, error)
-	DeleteAllFiles(context.Context) error
 	StoreTree(context.Context, *merkle.Tree) error
-	RetrieveTree(context.Context) (*merkle.Tree, error)
+	StoreDag(context.Context, *dag.Node) error
+	// Commit stores the tree head and atomically makes the staged batch the last one, the batch
+	// size and file count are set from the staged files.
+	Commit(context.Context, sth.SignedTreeHead) error
+	// Rollback discards the staged batch, it does nothing once the batch is committed.
+	Rollback(context.Context) error
+}
+
+// Snapshot is a consistent read only view of the committed batches, no commit or deletion is
+// visible to it while the View call it is passed to runs. It reads with the context of the View call.
+type Snapshot interface {
+	// Version is the id of the last committed batch, 0 if no batch is stored.
+	Version() int
+	RetrieveBlob(string) ([]byte, error)
+	// RetrieveBatches returns the committed batches, oldest first.
+	RetrieveBatches() ([]Batch, error)
+	RetrieveBatch(int) (Batch, error)
+	// RetrieveFileByIndex returns the file of the batch at the index, starting from 1.
+	RetrieveFileByIndex(batchID, i int) (StoredFile, error)
+	RetrieveBatchFiles(int) ([]StoredFile, error)
+	// RetrieveFiles returns at most limit files of the batch, in index order from the index.
+	RetrieveFiles(batchID, fromIndex, limit int) ([]StoredFile, error)
+	RetrieveTree(int) (*merkle.Tree, error)
+	// RetrieveDag returns the dag of the batch, nil if it was uploaded without one.
+	RetrieveDag(int) (*dag.Node, error)
+	RetrieveTreeHead(int) (sth.SignedTreeHead, error)
+}
+
+// viewValue reads a single value from a snapshot of the repository.
+func viewValue[T any](ctx context.Context, repository Repository, read func(Snapshot) (T, error)) (value T, err error) {
+	err = repository.View(ctx, func(snapshot Snapshot) (err error) {
+		value, err = read(snapshot)
+
+		return
+	})
+
+	return
 }
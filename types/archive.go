@@ -0,0 +1,25 @@
+package types
+
+import (
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
+
+// ManifestFilename is the name of the manifest entry of a batch archive.
+const ManifestFilename = "manifest.json"
+
+// ManifestFile describes a file of a batch archive along with its merkle proof.
+type ManifestFile struct {
+	UploadedFile
+	Mode        uint32       `json:"mode,omitempty"`
+	ModTime     int64        `json:"modTime,omitempty"`
+	MerkleProof merkle.Proof `json:"merkleProof"`
+}
+
+// BatchManifest is the first entry of a batch archive, it lists the archived files in index order.
+type BatchManifest struct {
+	TreeHead   sth.SignedTreeHead `json:"treeHead"`
+	Ordering   Ordering           `json:"ordering"`
+	LeafFormat merkle.LeafFormat  `json:"leafFormat"`
+	Files      []ManifestFile     `json:"files"`
+}
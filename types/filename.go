@@ -0,0 +1,29 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// NormalizeFileName returns the normalized, slash separated relative path of an uploaded file, the
+// name it is stored and committed to under. The client and the server normalize names alike.
+func NormalizeFileName(fileName string) (string, error) {
+	// control characters would be written as is to the terminal and the restored paths.
+	if fileName == "" || strings.ContainsFunc(fileName, isInvalidFileNameRune) {
+		return "", fmt.Errorf("invalid file name %q", fileName)
+	}
+
+	// reject absolute paths and the ones escaping the batch through "..".
+	cleanFileName := path.Clean(fileName)
+	if path.IsAbs(cleanFileName) || cleanFileName == "." || cleanFileName == ".." || strings.HasPrefix(cleanFileName, "../") {
+		return "", fmt.Errorf("file name %q is not a relative path", fileName)
+	}
+
+	return cleanFileName, nil
+}
+
+// isInvalidFileNameRune reports whether the rune is a backslash or an ASCII control character.
+func isInvalidFileNameRune(r rune) bool {
+	return r == '\\' || r < 0x20 || r == 0x7f
+}
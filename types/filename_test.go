@@ -0,0 +1,26 @@
+package types
+
+import "testing"
+
+func TestNormalizeFileName(t *testing.T) {
+	for fileName, expected := range map[string]string{
+		"a.txt":         "a.txt",
+		"dir/./b/../c":  "dir/c",
+		"dir//c/":       "dir/c",
+		"späce ünicode": "späce ünicode",
+	} {
+		normalized, err := NormalizeFileName(fileName)
+		if err != nil || normalized != expected {
+			t.Errorf("normalized %q to %q, expected %q: %v", fileName, normalized, expected, err)
+		}
+	}
+
+	for _, fileName := range []string{
+		"", ".", "..", "../a", "/a", "a/../..", `dir\a`,
+		"a\x00b", "a\nb", "a\rb", "a\tb", "\x1b[31mred", "a\x7fb",
+	} {
+		if normalized, err := NormalizeFileName(fileName); err == nil {
+			t.Errorf("normalized the invalid file name %q to %q", fileName, normalized)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package types
+
+// Http headers carrying the merkle proof of a downloaded file, so the content and its proof come
+// from the same tree version.
+const (
+	HeaderMerkleRoot     = "X-Merkle-Root"
+	HeaderMerkleTreeSize = "X-Merkle-Tree-Size"
+	HeaderMerkleIndex    = "X-Merkle-Index"
+	HeaderMerkleProof    = "X-Merkle-Proof"
+	// HeaderMerkleLeafFormat is the format of the leaf the proof starts from.
+	HeaderMerkleLeafFormat = "X-Merkle-Leaf-Format"
+)
+
+// Http headers carrying the file metadata, sent along with the file content on download and set
+// on the multipart file parts on upload.
+const (
+	HeaderFileName    = "X-File-Name"
+	HeaderFileMode    = "X-File-Mode"
+	HeaderFileModTime = "X-File-Mtime"
+)
+
+// FormFieldLeafFormat is the multipart form field carrying the leaf format of the uploaded files.
+const FormFieldLeafFormat = "leafFormat"
+
+// FormFieldDag is the multipart form field asking the server to build the directory structured dag.
+const FormFieldDag = "dag"
+
+// QueryWithProof is the query parameter asking the download endpoint to send the proof along.
+const QueryWithProof = "proof"
+
+// QueryIndex is the query parameter of the multi-proof endpoint, repeated for every file index to prove.
+const QueryIndex = "index"
+
+// QueryContent is the query parameter asking the dag endpoint for the file content instead of the proof.
+const QueryContent = "content"
+
+// Query parameters of the files listing endpoint, the batch defaults to the last one and the cursor
+// is the one returned by the previous page. The download and proof endpoints take the batch as well.
+const (
+	QueryBatch  = "batch"
+	QueryCursor = "cursor"
+	QueryLimit  = "limit"
+)
+
+// HeaderAPIKey is the http header carrying the api key of the client when the server requires one.
+const HeaderAPIKey = "X-Api-Key"
+
+// HeaderIdempotencyKey is the http header carrying the key of an upload, an upload sent again with
+// the same key gets the response of the first one instead of storing another batch.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderIdempotentReplayed is set by the server when it answers with the response of a previous
+// upload sent with the same idempotency key.
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
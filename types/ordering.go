@@ -0,0 +1,57 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Ordering is the order in which the files of a batch are placed as merkle tree leaves.
+type Ordering string
+
+const (
+	// OrderingUpload keeps the files in the order they are uploaded.
+	OrderingUpload Ordering = "upload"
+	// OrderingPath is the canonical order, files are sorted by the bytes of their normalized
+	// relative path, then by their leaf hash, so the same set of files always gets the same root.
+	OrderingPath Ordering = "path"
+)
+
+// FormFieldOrdering is the multipart form field carrying the ordering of the uploaded files.
+const FormFieldOrdering = "ordering"
+
+// ParseOrdering parses the ordering name, an empty name is the upload ordering.
+func ParseOrdering(name string) (Ordering, error) {
+	switch Ordering(name) {
+	case "", OrderingUpload:
+		return OrderingUpload, nil
+	case OrderingPath:
+		return OrderingPath, nil
+	default:
+		return "", fmt.Errorf("unknown ordering %q, expected %s or %s", name, OrderingUpload, OrderingPath)
+	}
+}
+
+// Permutation returns the positions of the files in the order of leaves, according to the file
+// names and leaf hashes at the upload positions.
+func (o Ordering) Permutation(names []string, leafHashes [][]byte) []int {
+	positions := make([]int, len(names))
+	for i := range positions {
+		positions[i] = i
+	}
+
+	if o != OrderingPath {
+		return positions
+	}
+
+	sort.SliceStable(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if names[a] != names[b] {
+			return names[a] < names[b]
+		}
+
+		return bytes.Compare(leafHashes[a], leafHashes[b]) < 0
+	})
+
+	return positions
+}
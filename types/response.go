@@ -1,19 +1,104 @@
 package types
 
-import "github.com/TxCorpi0x/file-upload-merkle/merkle"
+import (
+	"time"
+
+	"github.com/TxCorpi0x/file-upload-merkle/merkle"
+	"github.com/TxCorpi0x/file-upload-merkle/merkle/dag"
+	"github.com/TxCorpi0x/file-upload-merkle/sth"
+)
 
 // UploadedFile struct used for file names and index.
 type UploadedFile struct {
 	Name  string `json:"name"`
 	Index int    `json:"index"`
+	// Hash is the hexadecimal leaf hash the server computed for the file content.
+	Hash string `json:"hash"`
 }
 
 // UploadedFilesResponse is the http response for file uploader server endpoint.
 type UploadedFilesResponse struct {
 	UploadedFiles []UploadedFile `json:"uploadedFiles"`
+	// TreeHead describes the tree built by the server, signed if the server has a signing key.
+	TreeHead sth.SignedTreeHead `json:"treeHead"`
+	// Ordering is the order in which the server placed the files as tree leaves.
+	Ordering Ordering `json:"ordering"`
+	// LeafFormat is the format of the data committed to by the tree leaves.
+	LeafFormat merkle.LeafFormat `json:"leafFormat"`
 }
 
 // MerkleProofResponse is the http response of downloader server endpoint to get the proof of downloaded file.
 type MerkleProofResponse struct {
 	MerkleProof merkle.Proof `json:"merkleProof"`
 }
+
+// MultiProofResponse is the http response of the multi-proof server endpoint, proving several files
+// of the last batch at once. The leaf hashes are hexadecimal, in the order of the proof indexes.
+type MultiProofResponse struct {
+	Root       string            `json:"root"`
+	MultiProof merkle.MultiProof `json:"multiProof"`
+	LeafHashes []string          `json:"leafHashes"`
+}
+
+// DagPathResponse is the http response of the dag server endpoint, proving a path of the dag.
+type DagPathResponse struct {
+	Root  string        `json:"root"`
+	Proof dag.PathProof `json:"proof"`
+}
+
+// TreeHeadResponse is the http response of the tree head server endpoint.
+type TreeHeadResponse struct {
+	TreeHead sth.SignedTreeHead `json:"treeHead"`
+}
+
+// PublicKeyResponse is the http response of the server endpoint exposing the tree head signing key.
+type PublicKeyResponse struct {
+	PublicKey string `json:"publicKey"`
+	Algorithm string `json:"algorithm"`
+}
+
+// BatchFile describes a file of a stored batch.
+type BatchFile struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+	Size  int64  `json:"size"`
+}
+
+// BatchResponse is the http response of the batch server endpoint, the files are only listed when
+// a single batch is asked for.
+type BatchResponse struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Root is the hexadecimal merkle root of the batch.
+	Root       string            `json:"root"`
+	DagRoot    string            `json:"dagRoot,omitempty"`
+	Ordering   Ordering          `json:"ordering"`
+	LeafFormat merkle.LeafFormat `json:"leafFormat"`
+	// Size is the total size of the batch files.
+	Size      int64       `json:"size"`
+	FileCount int         `json:"fileCount"`
+	Files     []BatchFile `json:"files,omitempty"`
+}
+
+// BatchesResponse is the http response of the batches server endpoint, listing the stored batches
+// oldest first.
+type BatchesResponse struct {
+	Batches []BatchResponse `json:"batches"`
+}
+
+// FileEntry describes a file listed by the files server endpoint.
+type FileEntry struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+	Size  int64  `json:"size"`
+	// Hash is the hexadecimal leaf hash of the file.
+	Hash        string `json:"hash"`
+	ContentType string `json:"contentType"`
+}
+
+// FilesResponse is a page of the files of a batch, in index order. NextCursor is empty on the last page.
+type FilesResponse struct {
+	BatchID    int         `json:"batchId"`
+	Files      []FileEntry `json:"files"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,22 @@
+package types
+
+import "github.com/TxCorpi0x/file-upload-merkle/merkle"
+
+// UploadSessionRequest opens an upload session, whose files are sent one by one, possibly
+// concurrently, then committed at once as a batch.
+type UploadSessionRequest struct {
+	Ordering   Ordering          `json:"ordering"`
+	LeafFormat merkle.LeafFormat `json:"leafFormat"`
+	Dag        bool              `json:"dag"`
+}
+
+// UploadSessionResponse is the http response of the server endpoint opening an upload session.
+type UploadSessionResponse struct {
+	ID string `json:"id"`
+}
+
+// UploadSessionCommitRequest commits an upload session, whose files must have been sent at the
+// positions from 1 to the file count. The positions order the files as the upload ordering does.
+type UploadSessionCommitRequest struct {
+	FileCount int `json:"fileCount"`
+}